@@ -0,0 +1,128 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/telemetry"
+	log "github.com/sirupsen/logrus"
+)
+
+// Cluster is a cluster whose collections are reported by the admin endpoints.
+type Cluster struct {
+	Name     string
+	Interval time.Duration
+	Recorder *telemetry.Recorder
+}
+
+// ClusterStatus is the status of a cluster, as reported on /status.
+type ClusterStatus struct {
+	Name  string                 `json:"name,omitempty"`
+	Ready bool                   `json:"ready"`
+	Nodes []telemetry.NodeStatus `json:"nodes"`
+}
+
+// Handler serves /health, /ready and /status for a set of clusters which can
+// be replaced while serving.
+type Handler struct {
+	sync.Mutex
+	clusters       []Cluster
+	readyIntervals int
+	mux            *http.ServeMux
+	now            func() time.Time
+}
+
+// NewHandler returns a Handler considering a cluster ready when it was
+// successfully collected within the given number of intervals.
+func NewHandler(readyIntervals int) *Handler {
+	h := &Handler{readyIntervals: readyIntervals, mux: http.NewServeMux(), now: time.Now}
+
+	h.mux.HandleFunc("/health", h.health)
+	h.mux.HandleFunc("/ready", h.ready)
+	h.mux.HandleFunc("/status", h.status)
+
+	return h
+}
+
+// SetClusters replaces the clusters reported by the handler.
+func (h *Handler) SetClusters(clusters ...Cluster) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.clusters = clusters
+}
+
+// SetReadyIntervals replaces the number of intervals within which a cluster
+// must have been successfully collected to be ready.
+func (h *Handler) SetReadyIntervals(readyIntervals int) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.readyIntervals = readyIntervals
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Ready returns whether every cluster was successfully collected recently. A
+// cluster collected without an interval (e.g. on every Prometheus scrape) is
+// ready once it was successfully collected at all.
+func (h *Handler) Ready() bool {
+	h.Lock()
+	clusters, readyIntervals := h.clusters, h.readyIntervals
+	h.Unlock()
+
+	for _, c := range clusters {
+		if !h.isReady(c, readyIntervals) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *Handler) isReady(c Cluster, readyIntervals int) bool {
+	last := c.Recorder.LastSuccess()
+	if last.IsZero() {
+		return false
+	}
+
+	if c.Interval <= 0 {
+		return true
+	}
+
+	return h.now().Sub(last) <= time.Duration(readyIntervals)*c.Interval
+}
+
+func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK\n"))
+}
+
+func (h *Handler) ready(w http.ResponseWriter, r *http.Request) {
+	if !h.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("OK\n"))
+}
+
+func (h *Handler) status(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	clusters, readyIntervals := h.clusters, h.readyIntervals
+	h.Unlock()
+
+	status := []ClusterStatus{}
+	for _, c := range clusters {
+		status = append(status, ClusterStatus{Name: c.Name, Ready: h.isReady(c, readyIntervals), Nodes: c.Recorder.Status()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string][]ClusterStatus{"clusters": status}); err != nil {
+		log.WithField("error", err).Warn("unable to write status")
+	}
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/ruimarinho/nsq-dogstatsd/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+
+	return recorder
+}
+
+func TestHandler_Health(t *testing.T) {
+	response := get(t, NewHandler(3), "/health")
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "OK\n", response.Body.String())
+}
+
+func TestHandler_Ready(t *testing.T) {
+	foo := producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 4151, Hostname: "foo"}
+
+	recorder := telemetry.NewRecorder()
+	recorder.Nodes([]producer.Producer{foo})
+
+	handler := NewHandler(3)
+	handler.SetClusters(Cluster{Name: "foo", Interval: time.Minute, Recorder: recorder})
+
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, handler, "/ready").Code)
+
+	recorder.Collection(foo, time.Second, 1, 0, errors.New("foo"))
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, handler, "/ready").Code)
+
+	recorder.Collection(foo, time.Second, 1, 0, nil)
+	assert.Equal(t, http.StatusOK, get(t, handler, "/ready").Code)
+
+	// The last successful collection is too old.
+	handler.now = func() time.Time { return time.Now().Add(4 * time.Minute) }
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, handler, "/ready").Code)
+
+	handler.SetReadyIntervals(5)
+	assert.Equal(t, http.StatusOK, get(t, handler, "/ready").Code)
+
+	// Clusters without an interval are ready once successfully collected.
+	handler.SetClusters(Cluster{Name: "foo", Recorder: recorder})
+	handler.SetReadyIntervals(1)
+	assert.Equal(t, http.StatusOK, get(t, handler, "/ready").Code)
+
+	// Every cluster must be ready.
+	handler.SetClusters(Cluster{Name: "foo", Recorder: recorder}, Cluster{Name: "bar", Recorder: telemetry.NewRecorder()})
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, handler, "/ready").Code)
+}
+
+func TestHandler_Status(t *testing.T) {
+	foo := producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 4151, Hostname: "foo"}
+	bar := producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 4152, Hostname: "bar"}
+
+	recorder := telemetry.NewRecorder()
+	recorder.Nodes([]producer.Producer{foo, bar})
+	recorder.Collection(foo, time.Second, 10, 2, nil)
+
+	handler := NewHandler(3)
+	handler.SetClusters(Cluster{Name: "foo", Interval: time.Minute, Recorder: recorder})
+
+	response := get(t, handler, "/status")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+
+	var status struct {
+		Clusters []ClusterStatus `json:"clusters"`
+	}
+
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+	assert.Len(t, status.Clusters, 1)
+	assert.Equal(t, "foo", status.Clusters[0].Name)
+	assert.True(t, status.Clusters[0].Ready)
+	assert.Len(t, status.Clusters[0].Nodes, 2)
+
+	assert.Equal(t, "127.0.0.1:4151", status.Clusters[0].Nodes[0].Address)
+	assert.Equal(t, 10, status.Clusters[0].Nodes[0].Metrics)
+	assert.Equal(t, 2, status.Clusters[0].Nodes[0].Excluded)
+	assert.NotNil(t, status.Clusters[0].Nodes[0].LastSuccess)
+
+	assert.Equal(t, "127.0.0.1:4152", status.Clusters[0].Nodes[1].Address)
+	assert.Nil(t, status.Clusters[0].Nodes[1].LastCollection)
+}
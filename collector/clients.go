@@ -0,0 +1,343 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nsqio/nsq/nsqd"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client metrics modes.
+const (
+	// ClientMetricsAll reports the metrics of every client, merging clients
+	// whose kept tags are the same.
+	ClientMetricsAll = "all"
+	// ClientMetricsSum reports the sum of the metrics of the clients of each
+	// channel.
+	ClientMetricsSum = "sum"
+	// ClientMetricsMax reports the maximum of the metrics of the clients of each
+	// channel.
+	ClientMetricsMax = "max"
+	// ClientMetricsHostname reports the sum of the metrics of the clients of
+	// each channel sharing the same hostname.
+	ClientMetricsHostname = "hostname"
+	// ClientMetricsAgent reports the sum of the metrics of the clients of each
+	// channel sharing the same user agent.
+	ClientMetricsAgent = "agent"
+	// ClientMetricsNone does not report any client metric.
+	ClientMetricsNone = "none"
+)
+
+// ClientMetricsModes are the supported client metrics modes.
+var ClientMetricsModes = []string{ClientMetricsAll, ClientMetricsSum, ClientMetricsMax, ClientMetricsHostname, ClientMetricsAgent, ClientMetricsNone}
+
+// ClientTags are the tags identifying a client, in the order they are added to
+// client metrics.
+var ClientTags = []string{"client_id", "client_agent", "client_hostname", "client_address"}
+
+// Compression algorithms of client connections.
+const (
+	CompressionNone    = "none"
+	CompressionSnappy  = "snappy"
+	CompressionDeflate = "deflate"
+)
+
+// clientValue is the value of a client metric. Values are summed when clients
+// are combined, unless max is set, in which case the highest one is kept.
+type clientValue struct {
+	name    string
+	value   float64
+	counter bool
+	max     bool
+	tags    []string
+}
+
+func (c *Collector) clientValues(client nsqd.ClientStats) []clientValue {
+	values := []clientValue{
+		{name: "client.state", value: float64(client.State), max: true},
+		{name: "client.ready_count", value: float64(client.ReadyCount)},
+		{name: "client.in_flight", value: float64(client.InFlightCount)},
+		{name: "client.messages", value: float64(client.MessageCount), counter: true},
+		{name: "client.finished", value: float64(client.FinishCount), counter: true},
+		{name: "client.requeued", value: float64(client.RequeueCount), counter: true},
+		{name: "client.sample_rate", value: float64(client.SampleRate), max: true},
+		{name: "client.tls", value: boolValue(client.TLS)},
+		{name: "client.authed", value: boolValue(client.Authed)},
+		{name: "client.compression", value: 1, tags: []string{"compression:" + compression(client)}},
+	}
+
+	// The connection time is unknown if not reported by nsqd.
+	if client.ConnectTime > 0 {
+		connected := c.now().Unix() - client.ConnectTime
+		if connected < 0 {
+			connected = 0
+		}
+
+		values = append(values, clientValue{name: "client.connected_seconds", value: float64(connected), max: true})
+	}
+
+	return values
+}
+
+// compression returns the compression algorithm negotiated by a client.
+func compression(client nsqd.ClientStats) string {
+	switch {
+	case client.Snappy:
+		return CompressionSnappy
+	case client.Deflate:
+		return CompressionDeflate
+	default:
+		return CompressionNone
+	}
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func clientTagValues(client nsqd.ClientStats) map[string]string {
+	return map[string]string{
+		"client_id":       client.ClientID,
+		"client_agent":    client.UserAgent,
+		"client_hostname": client.Hostname,
+		"client_address":  client.RemoteAddress,
+	}
+}
+
+// clientGroup holds the combined values of the clients sharing the same tags.
+type clientGroup struct {
+	tags    []string
+	values  []clientValue
+	seen    []bool
+	indexes map[string]int
+	clients int
+}
+
+// index returns the index of the combined value of a client value, adding it
+// to the group if needed.
+func (g *clientGroup) index(value clientValue) int {
+	key := value.name + "|" + strings.Join(value.tags, ",")
+
+	i, ok := g.indexes[key]
+	if !ok {
+		i = len(g.values)
+		g.indexes[key] = i
+		g.values = append(g.values, clientValue{name: value.name, counter: value.counter, max: value.max, tags: value.tags})
+		g.seen = append(g.seen, false)
+	}
+
+	return i
+}
+
+// clientMetrics returns the metrics of the clients of a channel according to
+// the client metrics mode. Counter deltas are computed for each client before
+// the clients are combined, so that a client disconnecting is not mistaken for
+// a counter reset. The state of combined clients is the highest of their
+// states, as are their connection ages and sample rates, whereas their TLS,
+// authentication and compression metrics count the connections using them.
+// Clients grouped by some of their tags also report the number of connections
+// of each group.
+func (c *Collector) clientMetrics(channelTags []string, clients []nsqd.ClientStats) []Metric {
+	if c.ClientMetrics == ClientMetricsNone {
+		return nil
+	}
+
+	var groups []*clientGroup
+	groupsByTags := map[string]*clientGroup{}
+
+	for _, client := range clients {
+		tagValues := clientTagValues(client)
+		tags := append([]string{}, channelTags...)
+		groupTags := append([]string{}, channelTags...)
+
+		for _, tag := range ClientTags {
+			tags = append(tags, fmt.Sprintf("%s:%s", tag, tagValues[tag]))
+
+			if c.keepsClientTag(tag) {
+				groupTags = append(groupTags, fmt.Sprintf("%s:%s", tag, tagValues[tag]))
+			}
+		}
+
+		key := strings.Join(groupTags, ",")
+
+		group, ok := groupsByTags[key]
+		if !ok {
+			group = &clientGroup{tags: groupTags, indexes: map[string]int{}}
+			groups = append(groups, group)
+			groupsByTags[key] = group
+		}
+
+		group.clients++
+
+		for _, value := range c.clientValues(client) {
+			i := group.index(value)
+
+			if value.counter && c.Counters != nil {
+				delta, ok := c.Counters.Delta(c.counterKey(value.name, append(c.Producer.GetTags(), tags...)), value.value, c.startTime)
+				if !ok {
+					continue
+				}
+
+				value.value = delta
+			}
+
+			combined := &group.values[i]
+
+			switch {
+			case !group.seen[i]:
+				combined.value = value.value
+			case value.max || c.ClientMetrics == ClientMetricsMax:
+				if value.value > combined.value {
+					combined.value = value.value
+				}
+			default:
+				combined.value += value.value
+			}
+
+			group.seen[i] = true
+		}
+	}
+
+	var metrics []Metric
+
+	for _, group := range groups {
+		for i, value := range group.values {
+			if !group.seen[i] {
+				log.Debugf("skipping metric %s until a previous sample is available", value.name)
+				continue
+			}
+
+			metric := c.newMetric(value.name, value.value, append(append([]string{}, group.tags...), value.tags...))
+			if metric.Name == "" {
+				continue
+			}
+
+			if value.counter && c.Counters != nil {
+				metric.Type = CountType
+			}
+
+			logMetric(metric)
+
+			metrics = append(metrics, metric)
+		}
+
+		if c.groupsClients() {
+			metrics = append(metrics, c.NewGauge("client.connections", group.clients, group.tags))
+		}
+	}
+
+	return metrics
+}
+
+// keepsClientTag returns whether client metrics are tagged with the given
+// client tag.
+func (c *Collector) keepsClientTag(tag string) bool {
+	switch c.ClientMetrics {
+	case ClientMetricsSum, ClientMetricsMax:
+		return false
+	case ClientMetricsHostname:
+		return tag == "client_hostname"
+	case ClientMetricsAgent:
+		return tag == "client_agent"
+	}
+
+	if c.ClientTags == nil {
+		return true
+	}
+
+	for _, clientTag := range c.ClientTags {
+		if tag == clientTag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupsClients returns whether client metrics are grouped by some, but not
+// all, of the client tags.
+func (c *Collector) groupsClients() bool {
+	kept := 0
+	for _, tag := range ClientTags {
+		if c.keepsClientTag(tag) {
+			kept++
+		}
+	}
+
+	return kept > 0 && kept < len(ClientTags)
+}
+
+// CapClientTagSets keeps client metrics as long as they have at most max unique
+// tag sets, dropping the metrics of any further tag set. Tag sets are kept in
+// the order of their node tag and then of the tag set itself, so that the same
+// clients are kept on every collection regardless of the order in which nodes
+// are collected. The compression tag is ignored, so that the metrics of a
+// client are either kept or dropped altogether. It returns the kept metrics and
+// the number of dropped ones. A max of 0 means no limit.
+func CapClientTagSets(metrics []Metric, max int) ([]Metric, int) {
+	if max <= 0 {
+		return metrics, 0
+	}
+
+	keys := make([]string, len(metrics))
+	nodes := map[string]string{}
+
+	for i, metric := range metrics {
+		if !strings.HasPrefix(metric.Name, "client.") {
+			continue
+		}
+
+		var tags []string
+		var node string
+		for _, tag := range metric.Tags {
+			if strings.HasPrefix(tag, "node:") {
+				node = tag
+			}
+
+			if !strings.HasPrefix(tag, "compression:") {
+				tags = append(tags, tag)
+			}
+		}
+
+		keys[i] = strings.Join(tags, ",")
+		nodes[keys[i]] = node
+	}
+
+	tagSets := make([]string, 0, len(nodes))
+	for key := range nodes {
+		tagSets = append(tagSets, key)
+	}
+
+	sort.Slice(tagSets, func(i, j int) bool {
+		if nodes[tagSets[i]] != nodes[tagSets[j]] {
+			return nodes[tagSets[i]] < nodes[tagSets[j]]
+		}
+
+		return tagSets[i] < tagSets[j]
+	})
+
+	kept := map[string]bool{}
+	for i := 0; i < len(tagSets) && i < max; i++ {
+		kept[tagSets[i]] = true
+	}
+
+	result := []Metric{}
+	dropped := 0
+
+	for i, metric := range metrics {
+		if strings.HasPrefix(metric.Name, "client.") && !kept[keys[i]] {
+			dropped++
+			continue
+		}
+
+		result = append(result, metric)
+	}
+
+	return result, dropped
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClients() []nsqd.ClientStats {
+	return []nsqd.ClientStats{
+		{ClientID: "a", Hostname: "worker-1", RemoteAddress: "10.0.0.1:40001", State: 3, ReadyCount: 10, InFlightCount: 2, MessageCount: 100, ConnectTime: 900, TLS: true, Authed: true, Snappy: true},
+		{ClientID: "a", Hostname: "worker-1", RemoteAddress: "10.0.0.1:40002", State: 2, ReadyCount: 5, InFlightCount: 1, MessageCount: 50, ConnectTime: 950, TLS: true, Snappy: true},
+		{ClientID: "b", Hostname: "worker-2", RemoteAddress: "10.0.0.2:40001", State: 3, ReadyCount: 1, InFlightCount: 4, MessageCount: 10, ConnectTime: 990, SampleRate: 10},
+	}
+}
+
+func TestCollector_clientMetrics(t *testing.T) {
+	var tests = []struct {
+		mode       string
+		clientTags []string
+		expected   map[string][]float64
+	}{
+		{ClientMetricsNone, nil, map[string][]float64{}},
+		{ClientMetricsSum, nil, map[string][]float64{
+			"node:localhost,channel:foo":                    {3, 16, 7, 160, 0, 0, 10, 2, 1, 100},
+			"node:localhost,channel:foo,compression:snappy": {2},
+			"node:localhost,channel:foo,compression:none":   {1},
+		}},
+		{ClientMetricsMax, nil, map[string][]float64{
+			"node:localhost,channel:foo":                    {3, 10, 4, 100, 0, 0, 10, 1, 1, 100},
+			"node:localhost,channel:foo,compression:snappy": {1},
+			"node:localhost,channel:foo,compression:none":   {1},
+		}},
+		{ClientMetricsAll, []string{"client_hostname"}, map[string][]float64{
+			"node:localhost,channel:foo,client_hostname:worker-1":                    {3, 15, 3, 150, 0, 0, 0, 2, 1, 100, 2},
+			"node:localhost,channel:foo,client_hostname:worker-1,compression:snappy": {2},
+			"node:localhost,channel:foo,client_hostname:worker-2":                    {3, 1, 4, 10, 0, 0, 10, 0, 0, 10, 1},
+			"node:localhost,channel:foo,client_hostname:worker-2,compression:none":   {1},
+		}},
+		{ClientMetricsHostname, []string{"client_id"}, map[string][]float64{
+			"node:localhost,channel:foo,client_hostname:worker-1":                    {3, 15, 3, 150, 0, 0, 0, 2, 1, 100, 2},
+			"node:localhost,channel:foo,client_hostname:worker-1,compression:snappy": {2},
+			"node:localhost,channel:foo,client_hostname:worker-2":                    {3, 1, 4, 10, 0, 0, 10, 0, 0, 10, 1},
+			"node:localhost,channel:foo,client_hostname:worker-2,compression:none":   {1},
+		}},
+		{ClientMetricsAgent, nil, map[string][]float64{
+			"node:localhost,channel:foo,client_agent:":                    {3, 16, 7, 160, 0, 0, 10, 2, 1, 100, 3},
+			"node:localhost,channel:foo,client_agent:,compression:snappy": {2},
+			"node:localhost,channel:foo,client_agent:,compression:none":   {1},
+		}},
+	}
+
+	for _, tt := range tests {
+		collector := NewCollector(producer.Producer{Hostname: "localhost"}, []*regexp.Regexp{})
+		collector.ClientMetrics = tt.mode
+		collector.ClientTags = tt.clientTags
+		collector.now = func() time.Time { return time.Unix(1000, 0) }
+
+		values := map[string][]float64{}
+		for _, metric := range collector.clientMetrics([]string{"channel:foo"}, newClients()) {
+			key := strings.Join(metric.Tags, ",")
+			values[key] = append(values[key], metric.Value)
+		}
+
+		assert.Equal(t, tt.expected, values, tt.mode)
+	}
+
+	collector := NewCollector(producer.Producer{Hostname: "localhost"}, []*regexp.Regexp{})
+	assert.Len(t, collector.clientMetrics([]string{"channel:foo"}, newClients()), 33)
+}
+
+func TestCollector_clientMetrics_Counters(t *testing.T) {
+	clients := newClients()
+
+	collector := NewCollector(producer.Producer{Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("client.(state|ready_count|in_flight|finished|requeued|connected_seconds|sample_rate|tls|authed|compression)")})
+	collector.Counters = NewCounters()
+	collector.ClientMetrics = ClientMetricsSum
+	assert.Empty(t, collector.clientMetrics([]string{"channel:foo"}, clients))
+
+	// The deltas of the remaining clients are summed, ignoring the client which
+	// disconnected.
+	clients[0].MessageCount += 5
+	clients[2].MessageCount += 1
+
+	assert.Equal(t, []Metric{{
+		Name:  "client.messages",
+		Rate:  1,
+		Type:  CountType,
+		Tags:  []string{"node:localhost", "channel:foo"},
+		Value: 6,
+	}}, collector.clientMetrics([]string{"channel:foo"}, []nsqd.ClientStats{clients[0], clients[2]}))
+}
+
+func TestCapClientTagSets(t *testing.T) {
+	metrics := []Metric{
+		{Name: "channel.depth", Tags: []string{"channel:foo"}},
+		{Name: "client.in_flight", Tags: []string{"client_id:a"}},
+		{Name: "client.ready_count", Tags: []string{"client_id:a"}},
+		{Name: "client.in_flight", Tags: []string{"client_id:b"}},
+		{Name: "client.ready_count", Tags: []string{"client_id:b"}},
+		{Name: "client.compression", Tags: []string{"client_id:b", "compression:none"}},
+		{Name: "channel.depth", Tags: []string{"channel:bar"}},
+	}
+
+	result, dropped := CapClientTagSets(metrics, 0)
+	assert.Equal(t, metrics, result)
+	assert.Zero(t, dropped)
+
+	result, dropped = CapClientTagSets(metrics, 1)
+	assert.Equal(t, []Metric{metrics[0], metrics[1], metrics[2], metrics[6]}, result)
+	assert.Equal(t, 3, dropped)
+}
+
+func TestCapClientTagSets_Order(t *testing.T) {
+	metrics := []Metric{
+		{Name: "client.in_flight", Tags: []string{"client_id:a", "node:nsqd-2:4151"}},
+		{Name: "client.in_flight", Tags: []string{"client_id:c", "node:nsqd-1:4151"}},
+		{Name: "client.in_flight", Tags: []string{"client_id:b", "node:nsqd-1:4151"}},
+	}
+
+	// Tag sets are kept by node and then by tag set, whatever the order in
+	// which nodes were collected.
+	result, dropped := CapClientTagSets(metrics, 2)
+	assert.Equal(t, []Metric{metrics[1], metrics[2]}, result)
+	assert.Equal(t, 1, dropped)
+
+	result, _ = CapClientTagSets([]Metric{metrics[2], metrics[0], metrics[1]}, 1)
+	assert.Equal(t, []Metric{metrics[2]}, result)
+}
@@ -2,7 +2,6 @@ package collector
 
 import (
 	"encoding/json"
-	"fmt"
 
 	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
 	"github.com/ruimarinho/nsq-dogstatsd/producer"
@@ -52,7 +51,7 @@ func (nc NSQDCollector) GetInfo() (Info, error) {
 	}
 
 	if info.StatusCode != 200 {
-		return info, fmt.Errorf("response code was %d", info.StatusCode)
+		return info, fetcher.StatusError{StatusCode: info.StatusCode}
 	}
 
 	return info, nil
@@ -73,7 +72,7 @@ func (nc NSQDCollector) GetNodes() (Nodes, error) {
 	}
 
 	if nodes.StatusCode != 200 {
-		return nodes, fmt.Errorf("response code was %d", nodes.StatusCode)
+		return nodes, fetcher.StatusError{StatusCode: nodes.StatusCode}
 	}
 
 	return nodes, nil
@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// Counters remembers the previous sample of monotonic counters (e.g. the
+// number of messages of a topic) so that deltas can be computed between
+// collections. It is safe for concurrent use.
+type Counters struct {
+	sync.Mutex
+	samples map[string]sample
+	now     func() time.Time
+}
+
+type sample struct {
+	value     float64
+	startTime int64
+	seenAt    time.Time
+}
+
+// NewCounters returns an empty set of counters.
+func NewCounters() *Counters {
+	return &Counters{samples: map[string]sample{}, now: time.Now}
+}
+
+// Delta records the value of the counter identified by key and returns the
+// difference to its previous sample. The second return value is false when
+// there is no previous sample to compare against.
+//
+// A counter is considered to have been reset, and its value is returned as the
+// delta, when the start time of the nsqd node changes (i.e. it was restarted)
+// or when its value decreases (e.g. a topic was deleted and created again).
+func (c *Counters) Delta(key string, value float64, startTime int64) (float64, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	previous, ok := c.samples[key]
+	c.samples[key] = sample{value: value, startTime: startTime, seenAt: c.now()}
+
+	if !ok {
+		return 0, false
+	}
+
+	if previous.startTime != startTime || value < previous.value {
+		return value, true
+	}
+
+	return value - previous.value, true
+}
+
+// Prune removes samples that have not been recorded since the given time, so
+// that counters of entities that no longer exist (e.g. disconnected clients)
+// do not accumulate.
+func (c *Counters) Prune(before time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, s := range c.samples {
+		if s.seenAt.Before(before) {
+			delete(c.samples, key)
+		}
+	}
+}
+
+// Len returns the number of samples currently remembered.
+func (c *Counters) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.samples)
+}
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters_Delta(t *testing.T) {
+	counters := NewCounters()
+
+	_, ok := counters.Delta("foo", 10, 1515289281)
+	assert.False(t, ok)
+
+	delta, ok := counters.Delta("foo", 15, 1515289281)
+	assert.True(t, ok)
+	assert.Equal(t, float64(5), delta)
+
+	delta, ok = counters.Delta("foo", 15, 1515289281)
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), delta)
+}
+
+func TestCounters_Delta_Reset(t *testing.T) {
+	counters := NewCounters()
+
+	counters.Delta("foo", 10, 1515289281)
+
+	delta, ok := counters.Delta("foo", 3, 1515289281)
+	assert.True(t, ok)
+	assert.Equal(t, float64(3), delta)
+
+	delta, ok = counters.Delta("foo", 20, 1515289999)
+	assert.True(t, ok)
+	assert.Equal(t, float64(20), delta)
+}
+
+func TestCounters_Prune(t *testing.T) {
+	now := time.Unix(1515289281, 0)
+
+	counters := NewCounters()
+	counters.now = func() time.Time { return now }
+	counters.Delta("foo", 1, 1515289281)
+
+	now = now.Add(time.Minute)
+	counters.Delta("bar", 1, 1515289281)
+
+	counters.Prune(now)
+
+	assert.Equal(t, 1, counters.Len())
+
+	_, ok := counters.Delta("foo", 2, 1515289281)
+	assert.False(t, ok)
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"strconv"
+)
+
+// Units of end-to-end processing latency metrics.
+const (
+	LatencyUnitNanoseconds  = "ns"
+	LatencyUnitMilliseconds = "ms"
+	LatencyUnitSeconds      = "s"
+)
+
+// LatencyUnits are the supported units of end-to-end processing latency metrics.
+var LatencyUnits = []string{LatencyUnitNanoseconds, LatencyUnitMilliseconds, LatencyUnitSeconds}
+
+// latencyDivisors convert nanoseconds, as reported by nsqd, into each unit.
+var latencyDivisors = map[string]float64{
+	LatencyUnitNanoseconds:  1,
+	LatencyUnitMilliseconds: 1e6,
+	LatencyUnitSeconds:      1e9,
+}
+
+// latencyMetrics returns a metric for every percentile of an end-to-end
+// processing latency, tagged by its quantile (e.g. quantile:0.99) and converted
+// into the latency unit of the collector. Percentiles are reported as gauges or,
+// if the collector reports latencies as distributions, as distributions.
+func (c *Collector) latencyMetrics(name string, percentiles []map[string]float64, extraTags []string) []Metric {
+	divisor, ok := latencyDivisors[c.LatencyUnit]
+	if !ok {
+		divisor = 1
+	}
+
+	metrics := []Metric{}
+
+	for _, percentile := range percentiles {
+		tags := append(append([]string{}, extraTags...), "quantile:"+strconv.FormatFloat(percentile["quantile"], 'f', -1, 64))
+
+		metric := c.newMetric(name, percentile["value"]/divisor, tags)
+		if metric.Name == "" {
+			continue
+		}
+
+		if c.LatencyDistribution {
+			metric.Type = DistributionType
+		}
+
+		logMetric(metric)
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics
+}
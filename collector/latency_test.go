@@ -0,0 +1,35 @@
+package collector
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyMetrics(t *testing.T) {
+	percentiles := []map[string]float64{
+		{"quantile": 0.5, "value": 2500000},
+		{"quantile": 0.99, "value": 40000000},
+	}
+
+	collector := NewCollector(producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 80, Hostname: "localhost"}, []*regexp.Regexp{})
+
+	assert.Equal(t, []Metric{
+		NewMetric("topic.e2e_processing_latency", 2500000, []string{"node:localhost", "topic:foo", "quantile:0.5"}),
+		NewMetric("topic.e2e_processing_latency", 40000000, []string{"node:localhost", "topic:foo", "quantile:0.99"}),
+	}, collector.latencyMetrics("topic.e2e_processing_latency", percentiles, []string{"topic:foo"}))
+
+	collector.LatencyUnit = LatencyUnitMilliseconds
+	collector.LatencyDistribution = true
+
+	assert.Equal(t, []Metric{
+		{Name: "topic.e2e_processing_latency", Type: DistributionType, Value: 2.5, Rate: 1, Tags: []string{"node:localhost", "topic:foo", "quantile:0.5"}},
+		{Name: "topic.e2e_processing_latency", Type: DistributionType, Value: 40, Rate: 1, Tags: []string{"node:localhost", "topic:foo", "quantile:0.99"}},
+	}, collector.latencyMetrics("topic.e2e_processing_latency", percentiles, []string{"topic:foo"}))
+
+	collector.ExcludedMetrics = []*regexp.Regexp{regexp.MustCompile("latency")}
+
+	assert.Empty(t, collector.latencyMetrics("topic.e2e_processing_latency", percentiles, []string{"topic:foo"}))
+}
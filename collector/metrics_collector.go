@@ -4,47 +4,238 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"strings"
+	"time"
 
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
 	"github.com/ruimarinho/nsq-dogstatsd/producer"
 	log "github.com/sirupsen/logrus"
 )
 
-// Metric holds a statistical metric from nsqd.
+// Metric types supported by the collector.
+const (
+	GaugeType        = "gauge"
+	CountType        = "count"
+	DistributionType = "distribution"
+	ServiceCheckType = "service_check"
+)
+
+// ServiceCheckStatus represents the status of a service check, using the same
+// values as DogStatsD.
+type ServiceCheckStatus int
+
+// Service check statuses.
+const (
+	ServiceCheckOK ServiceCheckStatus = iota
+	ServiceCheckWarning
+	ServiceCheckCritical
+	ServiceCheckUnknown
+)
+
+// Metric holds a statistical metric from nsqd. Service checks are also
+// represented as metrics, in which case the value holds the status.
 type Metric struct {
-	Name  string
-	Rate  float64
-	Tags  []string
-	Type  string
-	Value float64
+	Name    string
+	Rate    float64
+	Tags    []string
+	Type    string
+	Value   float64
+	Message string
 }
 
 type Collector struct {
 	Producer        producer.Producer
 	ExcludedMetrics []*regexp.Regexp
+	// IncludedMetrics, when set, restricts the metrics to those matching any of
+	// its filters. Excluded metrics take precedence over included ones.
+	IncludedMetrics []parser.MetricFilter
+	// Counters holds the previous samples of monotonic counters. When set,
+	// counters are reported as deltas (counts) instead of cumulative gauges.
+	Counters *Counters
+	// Client fetches the statistics of the producer, using the default client
+	// when unset.
+	Client *fetcher.Client
+	// StatsFilter selects the topics, channels and clients to collect.
+	StatsFilter producer.StatsFilter
+	// TagFilter skips topics, channels, clients and nodes before any of their
+	// metrics are built.
+	TagFilter TagFilter
+	// ClientMetrics is the client metrics mode, reporting every client when
+	// unset.
+	ClientMetrics string
+	// ClientTags are the client tags kept on client metrics, keeping all of them
+	// when nil.
+	ClientTags []string
+	// LatencyUnit is the unit of end-to-end processing latency metrics,
+	// reporting nanoseconds when unset.
+	LatencyUnit string
+	// LatencyDistribution reports end-to-end processing latencies as
+	// distributions instead of gauges.
+	LatencyDistribution bool
+	// VersionTag tags every metric of the node with its nsqd version, instead of
+	// only node.info.
+	VersionTag bool
+	// Excluded is the number of metrics skipped due to ExcludedMetrics.
+	Excluded  int
+	startTime int64
+	version   string
+	now       func() time.Time
 }
 
 func NewMetric(metric string, value float64, tags []string) Metric {
 	return Metric{
 		Name:  metric,
 		Value: value,
-		Type:  "gauge",
+		Type:  GaugeType,
 		Tags:  tags,
 		Rate:  1,
 	}
 }
 
+func NewServiceCheckMetric(name string, status ServiceCheckStatus, message string, tags []string) Metric {
+	return Metric{
+		Name:    name,
+		Value:   float64(status),
+		Type:    ServiceCheckType,
+		Tags:    tags,
+		Message: message,
+	}
+}
+
 func NewCollector(producer producer.Producer, excludedMetrics []*regexp.Regexp) *Collector {
-	return &Collector{Producer: producer, ExcludedMetrics: excludedMetrics}
+	return &Collector{Producer: producer, ExcludedMetrics: excludedMetrics, now: time.Now}
 }
 
-func (c *Collector) NewGauge(name string, value interface{}, extraTags []string) Metric {
-	tags := append(c.Producer.GetTags(), extraTags...)
+// isExcluded returns whether a metric is skipped, either because it does not
+// match any included metric or because it matches an excluded one.
+func (c *Collector) isExcluded(name string, tags []string) bool {
+	if len(c.IncludedMetrics) > 0 && !c.isIncluded(name, tags) {
+		log.Debugf("skipping metric %s", name)
+		c.Excluded++
+		return true
+	}
 
 	for _, filter := range c.ExcludedMetrics {
 		if filter.MatchString(name) {
 			log.Debugf("skipping metric %s", name)
+			c.Excluded++
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter returns the given metrics except for the excluded ones, for metrics
+// built outside of the collector such as cluster rollups.
+func (c *Collector) Filter(metrics []Metric) []Metric {
+	result := []Metric{}
+
+	for _, metric := range metrics {
+		if !c.isExcluded(metric.Name, metric.Tags) {
+			result = append(result, metric)
+		}
+	}
+
+	return result
+}
+
+func (c *Collector) isIncluded(name string, tags []string) bool {
+	for _, filter := range c.IncludedMetrics {
+		if filter.Match(name, tags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Collector) NewServiceCheck(name string, status ServiceCheckStatus, message string, extraTags []string) Metric {
+	tags := append(c.nodeTags(), extraTags...)
+
+	if c.isExcluded(name, tags) {
+		return Metric{}
+	}
+
+	metric := NewServiceCheckMetric(name, status, message, tags)
+
+	log.WithFields(log.Fields{
+		"name":    metric.Name,
+		"status":  metric.Value,
+		"message": metric.Message,
+		"tags":    metric.Tags,
+	}).Debugf("collecting service check %s", metric.Name)
+
+	return metric
+}
+
+func (c *Collector) NewGauge(name string, value interface{}, extraTags []string) Metric {
+	metric := c.newMetric(name, value, extraTags)
+	if metric.Name == "" {
+		return metric
+	}
+
+	logMetric(metric)
+
+	return metric
+}
+
+// NewCounter returns a metric for a monotonic counter. If the collector keeps
+// track of counters, the metric is a count holding the delta since the previous
+// collection (or empty if there is no previous sample). Otherwise, the
+// cumulative value is returned as a gauge.
+func (c *Collector) NewCounter(name string, value interface{}, extraTags []string) Metric {
+	metric := c.newMetric(name, value, extraTags)
+	if metric.Name == "" {
+		return metric
+	}
+
+	if c.Counters != nil {
+		delta, ok := c.Counters.Delta(c.counterKey(metric.Name, metric.Tags), metric.Value, c.startTime)
+		if !ok {
+			log.Debugf("skipping metric %s until a previous sample is available", name)
 			return Metric{}
 		}
+
+		metric.Type = CountType
+		metric.Value = delta
+	}
+
+	logMetric(metric)
+
+	return metric
+}
+
+// nodeTags returns the tags added to every metric of the node.
+func (c *Collector) nodeTags() []string {
+	if c.VersionTag {
+		return append(c.Producer.GetTags(), c.versionTags()...)
+	}
+
+	return c.Producer.GetTags()
+}
+
+// versionTags returns the tag holding the nsqd version of the node, if known.
+func (c *Collector) versionTags() []string {
+	if c.version == "" {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("nsqd_version:%s", c.version)}
+}
+
+// counterKey identifies a counter across collections.
+func (c *Collector) counterKey(name string, tags []string) string {
+	return fmt.Sprintf("%s|%s|%s", c.Producer.HTTPAddress(), name, strings.Join(tags, ","))
+}
+
+func (c *Collector) newMetric(name string, value interface{}, extraTags []string) Metric {
+	tags := append(c.nodeTags(), extraTags...)
+
+	if c.isExcluded(name, tags) {
+		return Metric{}
 	}
 
 	var metric Metric
@@ -73,6 +264,10 @@ func (c *Collector) NewGauge(name string, value interface{}, extraTags []string)
 		return metric
 	}
 
+	return metric
+}
+
+func logMetric(metric Metric) {
 	log.WithFields(log.Fields{
 		"name":  metric.Name,
 		"value": metric.Value,
@@ -80,19 +275,65 @@ func (c *Collector) NewGauge(name string, value interface{}, extraTags []string)
 		"tags":  metric.Tags,
 		"rate":  metric.Rate,
 	}).Debugf("collecting metric %s", metric.Name)
+}
 
-	return metric
+// SkippedMetrics returns the metrics of a node which is not collected due to
+// backoff, reporting it as unreachable without querying it.
+func (c *Collector) SkippedMetrics() []Metric {
+	if c.TagFilter.Skips("node", c.Producer.Hostname) {
+		return []Metric{}
+	}
+
+	c.version = c.Producer.Version
+
+	return compact([]Metric{c.NewServiceCheck("node.can_connect", ServiceCheckCritical, "skipped due to backoff after consecutive failures", []string{})})
 }
 
 func (c *Collector) CollectMetrics() ([]Metric, error) {
 	log.WithField("node", c.Producer.Hostname).Debugf(`collecting metrics for node %s`, c.Producer.Hostname)
 
-	stats, err := c.Producer.GetStats()
+	if c.TagFilter.Skips("node", c.Producer.Hostname) {
+		log.WithField("node", c.Producer.Hostname).Debugf("skipping node %s", c.Producer.Hostname)
+		return []Metric{}, nil
+	}
+
+	c.version = c.Producer.Version
+
+	client := c.Client
+	if client == nil {
+		client = fetcher.DefaultClient
+	}
+
+	stats, err := c.Producer.GetStats(client, c.StatsFilter)
 	if err != nil {
-		return nil, err
+		// Report the node as unreachable alongside the error so that monitors can
+		// alert on it without relying on the absence of metrics.
+		return compact([]Metric{c.NewServiceCheck("node.can_connect", ServiceCheckCritical, err.Error(), []string{})}), err
+	}
+
+	c.startTime = stats.Data.StartTime
+
+	if stats.Data.Version != "" {
+		c.version = stats.Data.Version
+	}
+
+	infoTags := c.versionTags()
+	if c.VersionTag {
+		infoTags = nil
 	}
 
 	var metrics []Metric
+	metrics = append(metrics, c.NewServiceCheck("node.can_connect", ServiceCheckOK, "", []string{}))
+	metrics = append(metrics, c.NewServiceCheck("node.health", HealthStatus(stats.Data.Health), stats.Data.Health, []string{}))
+	metrics = append(metrics, c.NewGauge("node.info", 1, infoTags))
+
+	// The start time is unknown if not reported by nsqd.
+	if stats.Data.StartTime > 0 {
+		metrics = append(metrics, c.NewGauge("node.uptime_seconds", c.now().Unix()-stats.Data.StartTime, []string{}))
+	}
+
+	// Only the topics selected by the stats filter are counted, whether it was
+	// pushed down to nsqd or applied afterwards.
 	metrics = append(metrics, c.NewGauge("topic.count", len(stats.Data.Topics), []string{}))
 	metrics = append(metrics, c.NewGauge("memory.heap_objects", int64(stats.Data.Memory.HeapObjects), []string{}))
 	metrics = append(metrics, c.NewGauge("memory.heap_idle_bytes", int64(stats.Data.Memory.HeapIdleBytes), []string{}))
@@ -102,24 +343,37 @@ func (c *Collector) CollectMetrics() ([]Metric, error) {
 	metrics = append(metrics, c.NewGauge("memory.gc_pause_usec_99", int64(stats.Data.Memory.GCPauseUsec99), []string{}))
 	metrics = append(metrics, c.NewGauge("memory.gc_pause_usec_95", int64(stats.Data.Memory.GCPauseUsec95), []string{}))
 	metrics = append(metrics, c.NewGauge("memory.next_gc_bytes", int64(stats.Data.Memory.NextGCBytes), []string{}))
-	metrics = append(metrics, c.NewGauge("memory.gc_runs", int64(stats.Data.Memory.GCTotalRuns), []string{}))
+	metrics = append(metrics, c.NewCounter("memory.gc_runs", int64(stats.Data.Memory.GCTotalRuns), []string{}))
 
 	for _, topic := range stats.Data.Topics {
+		if c.TagFilter.Skips("topic", topic.TopicName) {
+			log.Debugf("skipping topic %s", topic.TopicName)
+			continue
+		}
+
 		topicTags := []string{fmt.Sprintf("topic:%s", topic.TopicName)}
 
 		metrics = append(metrics, c.NewGauge("topic.channels", len(topic.Channels), topicTags))
 		metrics = append(metrics, c.NewGauge("topic.depth", topic.Depth, topicTags))
 		metrics = append(metrics, c.NewGauge("topic.backend_depth", topic.BackendDepth, topicTags))
-		metrics = append(metrics, c.NewGauge("topic.messages", topic.MessageCount, topicTags))
+		metrics = append(metrics, c.NewCounter("topic.messages", topic.MessageCount, topicTags))
+
+		if topic.MessageBytes != nil {
+			metrics = append(metrics, c.NewCounter("topic.message_bytes", *topic.MessageBytes, topicTags))
+		}
+
 		metrics = append(metrics, c.NewGauge("topic.paused", topic.Paused, topicTags))
 
 		if topic.E2eProcessingLatency != nil {
-			for _, percentile := range topic.E2eProcessingLatency.Percentiles {
-				metrics = append(metrics, c.NewGauge(fmt.Sprintf("topic.e2e_processing_latency_%f", percentile["quantile"]), percentile["value"], topicTags))
-			}
+			metrics = append(metrics, c.latencyMetrics("topic.e2e_processing_latency", topic.E2eProcessingLatency.Percentiles, topicTags)...)
 		}
 
 		for _, channel := range topic.Channels {
+			if c.TagFilter.Skips("channel", channel.ChannelName) {
+				log.Debugf("skipping channel %s of topic %s", channel.ChannelName, topic.TopicName)
+				continue
+			}
+
 			channelTags := append([]string{}, topicTags...)
 			channelTags = append(channelTags, []string{fmt.Sprintf("channel:%s", channel.ChannelName)}...)
 
@@ -127,37 +381,58 @@ func (c *Collector) CollectMetrics() ([]Metric, error) {
 			metrics = append(metrics, c.NewGauge("channel.backend_depth", channel.BackendDepth, channelTags))
 			metrics = append(metrics, c.NewGauge("channel.in_flight", channel.InFlightCount, channelTags))
 			metrics = append(metrics, c.NewGauge("channel.deferred", channel.DeferredCount, channelTags))
-			metrics = append(metrics, c.NewGauge("channel.messages", channel.MessageCount, channelTags))
-			metrics = append(metrics, c.NewGauge("channel.requeued", channel.RequeueCount, channelTags))
-			metrics = append(metrics, c.NewGauge("channel.timed_out", channel.TimeoutCount, channelTags))
-			metrics = append(metrics, c.NewGauge("channel.clients", len(channel.Clients), channelTags))
+			metrics = append(metrics, c.NewCounter("channel.messages", channel.MessageCount, channelTags))
+			metrics = append(metrics, c.NewCounter("channel.requeued", channel.RequeueCount, channelTags))
+			metrics = append(metrics, c.NewCounter("channel.timed_out", channel.TimeoutCount, channelTags))
+			// Clients are only listed when requested, but are always counted by
+			// recent versions of nsqd.
+			clients := len(channel.Clients)
+			if channel.ClientCount > clients {
+				clients = channel.ClientCount
+			}
+
+			metrics = append(metrics, c.NewGauge("channel.clients", clients, channelTags))
 			metrics = append(metrics, c.NewGauge("channel.paused", channel.Paused, channelTags))
 
 			if channel.E2eProcessingLatency != nil {
-				for _, percentile := range channel.E2eProcessingLatency.Percentiles {
-					metrics = append(metrics, c.NewGauge(fmt.Sprintf("channel.e2e_processing_latency_%f", percentile["quantile"]), percentile["value"], channelTags))
-				}
+				metrics = append(metrics, c.latencyMetrics("channel.e2e_processing_latency", channel.E2eProcessingLatency.Percentiles, channelTags)...)
 			}
 
+			var channelClients []nsqd.ClientStats
 			for _, client := range channel.Clients {
-				clientTags := append([]string{}, channelTags...)
-				clientTags = append(clientTags, []string{
-					fmt.Sprintf("client_id:%s", client.ClientID),
-					fmt.Sprintf("client_agent:%s", client.UserAgent),
-					fmt.Sprintf("client_hostname:%s", client.Hostname),
-					fmt.Sprintf("client_address:%s", client.RemoteAddress)}...,
-				)
-
-				metrics = append(metrics, c.NewGauge("client.state", client.State, clientTags))
-				metrics = append(metrics, c.NewGauge("client.ready_count", client.ReadyCount, clientTags))
-				metrics = append(metrics, c.NewGauge("client.in_flight", client.InFlightCount, clientTags))
-				metrics = append(metrics, c.NewGauge("client.messages", client.MessageCount, clientTags))
-				metrics = append(metrics, c.NewGauge("client.finished", client.FinishCount, clientTags))
-				metrics = append(metrics, c.NewGauge("client.requeued", client.RequeueCount, clientTags))
+				if !c.TagFilter.Skips("client_hostname", client.Hostname) {
+					channelClients = append(channelClients, client)
+				}
 			}
+
+			metrics = append(metrics, c.clientMetrics(channelTags, channelClients)...)
 		}
 	}
 
+	metrics = append(metrics, c.producerMetrics(stats.Data.Producers)...)
+
+	result := compact(metrics)
+
+	log.WithFields(log.Fields{"node": c.Producer.Hostname}).Infof(`collected metrics for node %s`, c.Producer.Hostname)
+
+	return result, nil
+}
+
+// HealthStatus maps the health string reported by nsqd (e.g. "OK" or
+// "NOK - <reason>") to a service check status.
+func HealthStatus(health string) ServiceCheckStatus {
+	switch {
+	case health == "OK":
+		return ServiceCheckOK
+	case strings.HasPrefix(health, "NOK"):
+		return ServiceCheckCritical
+	default:
+		return ServiceCheckWarning
+	}
+}
+
+// compact removes empty metrics (e.g. excluded ones) from a slice of metrics.
+func compact(metrics []Metric) []Metric {
 	result := []Metric{}
 	for i := range metrics {
 		if metrics[i].Name != "" {
@@ -165,7 +440,5 @@ func (c *Collector) CollectMetrics() ([]Metric, error) {
 		}
 	}
 
-	log.WithFields(log.Fields{"node": c.Producer.Hostname}).Infof(`collected metrics for node %s`, c.Producer.Hostname)
-
-	return result, nil
+	return result
 }
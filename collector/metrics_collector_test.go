@@ -1,14 +1,18 @@
 package collector
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
 	"github.com/ruimarinho/nsq-dogstatsd/producer"
 	"github.com/stretchr/testify/assert"
 )
@@ -50,6 +54,25 @@ func TestNewGauge_ExcludedMetrics(t *testing.T) {
 	metric := collector.NewGauge("qux", float64(1), []string{"foo:tag"})
 
 	assert.Empty(t, metric)
+	assert.Equal(t, 1, collector.Excluded)
+}
+
+func TestNewGauge_IncludedMetrics(t *testing.T) {
+	included, err := parser.ParseMetricFilters([]string{`topic\..*`, "channel.depth channel:bar*"})
+	assert.NoError(t, err)
+
+	collector := NewCollector(producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 80, Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("topic.paused")})
+	collector.IncludedMetrics = included
+
+	assert.NotEmpty(t, collector.NewGauge("topic.depth", 1, []string{"topic:foo"}))
+	assert.NotEmpty(t, collector.NewGauge("channel.depth", 1, []string{"topic:foo", "channel:barbaz"}))
+	assert.Empty(t, collector.NewGauge("channel.depth", 1, []string{"topic:foo", "channel:baz"}))
+	assert.Empty(t, collector.NewGauge("node.uptime", 1, nil))
+	assert.Empty(t, collector.NewServiceCheck("node.health", ServiceCheckOK, "", nil))
+
+	// Excluded metrics take precedence over included ones.
+	assert.Empty(t, collector.NewGauge("topic.paused", 1, []string{"topic:foo"}))
+	assert.Equal(t, 4, collector.Excluded)
 }
 
 func TestCollectMetrics(t *testing.T) {
@@ -85,7 +108,12 @@ func TestCollectMetrics(t *testing.T) {
 								"message_count": 3,
 								"finish_count": 4,
 								"requeue_count": 5,
-								"user_agent": "foo"
+								"user_agent": "foo",
+								"connect_ts": 1515289341,
+								"sample_rate": 0,
+								"tls": true,
+								"deflate": true,
+								"authed": true
 							}],
 							"paused": true
 						}],
@@ -122,10 +150,38 @@ func TestCollectMetrics(t *testing.T) {
 	assert.Nil(t, err)
 
 	collector := NewCollector(producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "localhost"}, []*regexp.Regexp{})
+	collector.now = func() time.Time { return time.Unix(1515289401, 0) }
 	metrics, err := collector.CollectMetrics()
 	assert.Nil(t, err)
 
 	expected := []Metric{
+		Metric{
+			Name:  "node.can_connect",
+			Type:  "service_check",
+			Tags:  []string{"node:localhost"},
+			Value: 0,
+		},
+		Metric{
+			Name:    "node.health",
+			Type:    "service_check",
+			Tags:    []string{"node:localhost"},
+			Value:   0,
+			Message: "OK",
+		},
+		Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "nsqd_version:1.0.0-compat"},
+			Name:  "node.info",
+			Value: 1,
+		},
+		Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost"},
+			Name:  "node.uptime_seconds",
+			Value: 120,
+		},
 		Metric{
 			Rate:  1,
 			Type:  "gauge",
@@ -328,6 +384,36 @@ func TestCollectMetrics(t *testing.T) {
 			Tags:  []string{"node:localhost", "topic:foobar3000", "channel:foo", "client_id:foo", "client_agent:foo", "client_hostname:foo", "client_address:foo"},
 			Name:  "client.requeued",
 			Value: 5,
+		}, Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "topic:foobar3000", "channel:foo", "client_id:foo", "client_agent:foo", "client_hostname:foo", "client_address:foo"},
+			Name:  "client.sample_rate",
+			Value: 0,
+		}, Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "topic:foobar3000", "channel:foo", "client_id:foo", "client_agent:foo", "client_hostname:foo", "client_address:foo"},
+			Name:  "client.tls",
+			Value: 1,
+		}, Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "topic:foobar3000", "channel:foo", "client_id:foo", "client_agent:foo", "client_hostname:foo", "client_address:foo"},
+			Name:  "client.authed",
+			Value: 1,
+		}, Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "topic:foobar3000", "channel:foo", "client_id:foo", "client_agent:foo", "client_hostname:foo", "client_address:foo", "compression:deflate"},
+			Name:  "client.compression",
+			Value: 1,
+		}, Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "topic:foobar3000", "channel:foo", "client_id:foo", "client_agent:foo", "client_hostname:foo", "client_address:foo"},
+			Name:  "client.connected_seconds",
+			Value: 60,
 		},
 	}
 
@@ -373,10 +459,38 @@ func TestCollectMetrics_ExcludedMetrics(t *testing.T) {
 	assert.Nil(t, err)
 
 	collector := NewCollector(producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("memory.*")})
+	collector.now = func() time.Time { return time.Unix(1515289401, 0) }
 	metrics, err := collector.CollectMetrics()
 	assert.Nil(t, err)
 
 	expected := []Metric{
+		Metric{
+			Name:  "node.can_connect",
+			Type:  "service_check",
+			Tags:  []string{"node:localhost"},
+			Value: 0,
+		},
+		Metric{
+			Name:    "node.health",
+			Type:    "service_check",
+			Tags:    []string{"node:localhost"},
+			Value:   0,
+			Message: "OK",
+		},
+		Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost", "nsqd_version:1.0.0-compat"},
+			Name:  "node.info",
+			Value: 1,
+		},
+		Metric{
+			Rate:  1,
+			Type:  "gauge",
+			Tags:  []string{"node:localhost"},
+			Name:  "node.uptime_seconds",
+			Value: 120,
+		},
 		Metric{
 			Rate:  1,
 			Type:  "gauge",
@@ -407,7 +521,250 @@ func TestCollectMetrics_Error(t *testing.T) {
 	assert.Nil(t, err)
 
 	collector := NewCollector(producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "localhost"}, []*regexp.Regexp{})
-	_, errMetrics := collector.CollectMetrics()
+	metrics, errMetrics := collector.CollectMetrics()
 
 	assert.NotNil(t, errMetrics)
+	assert.Equal(t, []Metric{
+		Metric{
+			Name:    "node.can_connect",
+			Type:    "service_check",
+			Tags:    []string{"node:localhost"},
+			Value:   2,
+			Message: url.Host + " - response code was 500",
+		},
+	}, metrics)
+}
+
+func TestSkippedMetrics(t *testing.T) {
+	collector := NewCollector(producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 80, Hostname: "localhost"}, []*regexp.Regexp{})
+
+	assert.Equal(t, []Metric{
+		Metric{
+			Name:    "node.can_connect",
+			Type:    "service_check",
+			Tags:    []string{"node:localhost"},
+			Value:   2,
+			Message: "skipped due to backoff after consecutive failures",
+		},
+	}, collector.SkippedMetrics())
+
+	collector = NewCollector(producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 80, Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("^node.can_connect$")})
+	assert.Empty(t, collector.SkippedMetrics())
+}
+
+func TestNewServiceCheck(t *testing.T) {
+	collector := NewCollector(producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 80, Hostname: "localhost"}, []*regexp.Regexp{})
+	metric := collector.NewServiceCheck("node.health", ServiceCheckCritical, "NOK - foo", []string{"foo:tag"})
+
+	assert.Equal(t, Metric{
+		Name:    "node.health",
+		Value:   2,
+		Type:    "service_check",
+		Tags:    []string{"node:localhost", "foo:tag"},
+		Message: "NOK - foo",
+	}, metric)
+}
+
+func TestNewServiceCheck_ExcludedMetrics(t *testing.T) {
+	collector := NewCollector(producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 80, Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("node.*")})
+	metric := collector.NewServiceCheck("node.health", ServiceCheckOK, "", nil)
+
+	assert.Empty(t, metric)
+}
+
+func TestHealthStatus(t *testing.T) {
+	var tests = []struct {
+		health   string
+		expected ServiceCheckStatus
+	}{
+		{"OK", ServiceCheckOK},
+		{"NOK - failed to write to disk", ServiceCheckCritical},
+		{"", ServiceCheckWarning},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, HealthStatus(tt.health))
+	}
+}
+
+func TestCollectMetrics_Counters(t *testing.T) {
+	messageCount := 4
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf(`{
+				"status_code": 200,
+				"status_txt": "OK",
+				"data": {
+					"version": "1.0.0-compat",
+					"health": "OK",
+					"start_time": 1515289281,
+					"topics": [{
+						"topic_name": "foobar3000",
+						"channels": [],
+						"depth": 1,
+						"backend_depth": 3,
+						"message_count": %d,
+						"message_bytes": %d,
+						"paused": false
+					}]
+				}
+			}`, messageCount, messageCount*100)))
+		}))
+
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+
+	host, strPort, err := net.SplitHostPort(url.Host)
+	assert.Nil(t, err)
+
+	port, err := strconv.Atoi(strPort)
+	assert.Nil(t, err)
+
+	counters := NewCounters()
+	producer := producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "localhost"}
+	excludedMetrics := []*regexp.Regexp{regexp.MustCompile("^(node|memory|topic.count|topic.channels|topic.depth|topic.backend_depth|topic.paused)")}
+
+	collector := NewCollector(producer, excludedMetrics)
+	collector.Counters = counters
+	metrics, err := collector.CollectMetrics()
+	assert.Nil(t, err)
+	assert.Empty(t, metrics)
+
+	messageCount = 10
+
+	collector = NewCollector(producer, excludedMetrics)
+	collector.Counters = counters
+	metrics, err = collector.CollectMetrics()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []Metric{
+		Metric{
+			Rate:  1,
+			Type:  "count",
+			Tags:  []string{"node:localhost", "topic:foobar3000"},
+			Name:  "topic.messages",
+			Value: 6,
+		},
+		Metric{
+			Rate:  1,
+			Type:  "count",
+			Tags:  []string{"node:localhost", "topic:foobar3000"},
+			Name:  "topic.message_bytes",
+			Value: 600,
+		},
+	}, metrics)
+}
+
+func TestCollectMetrics_TagFilter(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			w.Write([]byte(`{
+				"status_code": 200,
+				"status_txt": "OK",
+				"data": {
+					"version": "1.0.0-compat",
+					"health": "OK",
+					"start_time": 1515289281,
+					"topics": [{
+						"topic_name": "orders",
+						"channels": [{
+							"channel_name": "billing",
+							"clients": [{"hostname": "worker-1"}, {"hostname": "test-worker"}]
+						}, {
+							"channel_name": "tail#ephemeral",
+							"clients": [{"hostname": "worker-2"}]
+						}]
+					}, {
+						"topic_name": "test_orders",
+						"channels": [{"channel_name": "billing"}]
+					}]
+				}
+			}`))
+		}))
+
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+
+	host, strPort, err := net.SplitHostPort(url.Host)
+	assert.Nil(t, err)
+
+	port, err := strconv.Atoi(strPort)
+	assert.Nil(t, err)
+
+	tagFilter, err := NewTagFilter([]string{"node:local*"}, []string{"topic:/^test_/", "channel:*#ephemeral", "client_hostname:test-*"})
+	assert.Nil(t, err)
+
+	collector := NewCollector(producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("^(node|memory|topic.count|client.compression)")})
+	collector.TagFilter = tagFilter
+	metrics, err := collector.CollectMetrics()
+	assert.Nil(t, err)
+
+	tags := map[string]bool{}
+	for _, metric := range metrics {
+		tags[strings.Join(metric.Tags[1:], ",")] = true
+	}
+
+	assert.Equal(t, map[string]bool{
+		"topic:orders":                 true,
+		"topic:orders,channel:billing": true,
+		"topic:orders,channel:billing,client_id:,client_agent:,client_hostname:worker-1,client_address:": true,
+	}, tags)
+
+	// Skipped nodes are not queried at all.
+	collector = NewCollector(producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "remotehost"}, []*regexp.Regexp{})
+	collector.TagFilter = tagFilter
+	metrics, err = collector.CollectMetrics()
+	assert.Nil(t, err)
+	assert.Empty(t, metrics)
+	assert.Equal(t, 1, requests)
+}
+
+func TestCollectMetrics_VersionTag(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{
+				"status_code": 200,
+				"status_txt": "OK",
+				"data": {
+					"version": "1.2.0",
+					"health": "OK",
+					"start_time": 1515289281,
+					"topics": []
+				}
+			}`))
+		}))
+
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	assert.Nil(t, err)
+
+	host, strPort, err := net.SplitHostPort(url.Host)
+	assert.Nil(t, err)
+
+	port, err := strconv.Atoi(strPort)
+	assert.Nil(t, err)
+
+	collector := NewCollector(producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: "localhost", Version: "1.1.0"}, []*regexp.Regexp{regexp.MustCompile("^(node.can_connect|node.health|memory)")})
+	collector.VersionTag = true
+	collector.now = func() time.Time { return time.Unix(1515289401, 0) }
+	metrics, err := collector.CollectMetrics()
+	assert.Nil(t, err)
+
+	// The version reported by the stats takes precedence over the version
+	// reported by nsqlookupd.
+	assert.Equal(t, []Metric{
+		NewMetric("node.info", 1, []string{"node:localhost", "nsqd_version:1.2.0"}),
+		NewMetric("node.uptime_seconds", 120, []string{"node:localhost", "nsqd_version:1.2.0"}),
+		NewMetric("topic.count", 0, []string{"node:localhost", "nsqd_version:1.2.0"}),
+	}, metrics)
 }
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nsqio/nsq/nsqd"
+	log "github.com/sirupsen/logrus"
+)
+
+// producerGroup holds the combined publish counts of the producer connections
+// publishing to the same topic from the same hostname and user agent.
+type producerGroup struct {
+	tags        []string
+	published   float64
+	seen        bool
+	connections int
+}
+
+// producerMetrics returns the metrics of the producers publishing to a node,
+// for every topic they publish to. Producer connections sharing the same
+// hostname and user agent are combined, computing the deltas of their publish
+// counts for each connection beforehand, like client metrics.
+func (c *Collector) producerMetrics(producers []nsqd.ClientStats) []Metric {
+	var groups []*producerGroup
+	groupsByTags := map[string]*producerGroup{}
+
+	for _, producer := range producers {
+		if c.TagFilter.Skips("client_hostname", producer.Hostname) {
+			log.Debugf("skipping producer %s", producer.Hostname)
+			continue
+		}
+
+		for _, pubCount := range producer.PubCounts {
+			if c.TagFilter.Skips("topic", pubCount.Topic) {
+				continue
+			}
+
+			tags := []string{
+				fmt.Sprintf("topic:%s", pubCount.Topic),
+				fmt.Sprintf("client_hostname:%s", producer.Hostname),
+				fmt.Sprintf("client_agent:%s", producer.UserAgent),
+			}
+
+			key := strings.Join(tags, ",")
+
+			group, ok := groupsByTags[key]
+			if !ok {
+				group = &producerGroup{tags: tags}
+				groups = append(groups, group)
+				groupsByTags[key] = group
+			}
+
+			group.connections++
+
+			published := float64(pubCount.Count)
+			if c.Counters != nil {
+				connectionTags := append(append(c.Producer.GetTags(), tags...), fmt.Sprintf("client_address:%s", producer.RemoteAddress))
+
+				delta, ok := c.Counters.Delta(c.counterKey("producer_client.published", connectionTags), published, c.startTime)
+				if !ok {
+					continue
+				}
+
+				published = delta
+			}
+
+			group.published += published
+			group.seen = true
+		}
+	}
+
+	var metrics []Metric
+
+	for _, group := range groups {
+		if group.seen {
+			metric := c.newMetric("producer_client.published", group.published, group.tags)
+			if metric.Name != "" {
+				if c.Counters != nil {
+					metric.Type = CountType
+				}
+
+				logMetric(metric)
+
+				metrics = append(metrics, metric)
+			}
+		} else {
+			log.Debugf("skipping metric producer_client.published until a previous sample is available")
+		}
+
+		metrics = append(metrics, c.NewGauge("producer_client.connections", group.connections, group.tags))
+	}
+
+	return compact(metrics)
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProducers() []nsqd.ClientStats {
+	return []nsqd.ClientStats{
+		{Hostname: "api-1", UserAgent: "go-nsq", RemoteAddress: "10.0.0.1:40001", PubCounts: []nsqd.PubCount{{Topic: "orders", Count: 10}, {Topic: "events", Count: 5}}},
+		{Hostname: "api-1", UserAgent: "go-nsq", RemoteAddress: "10.0.0.1:40002", PubCounts: []nsqd.PubCount{{Topic: "orders", Count: 20}}},
+		{Hostname: "test-api", UserAgent: "pynsq", RemoteAddress: "10.0.0.2:40001", PubCounts: []nsqd.PubCount{{Topic: "orders", Count: 1}}},
+	}
+}
+
+func TestCollector_producerMetrics(t *testing.T) {
+	tagFilter, err := NewTagFilter(nil, []string{"client_hostname:test-*"})
+	assert.NoError(t, err)
+
+	collector := NewCollector(producer.Producer{Hostname: "localhost"}, []*regexp.Regexp{})
+	collector.TagFilter = tagFilter
+
+	assert.Equal(t, []Metric{
+		NewMetric("producer_client.published", 30, []string{"node:localhost", "topic:orders", "client_hostname:api-1", "client_agent:go-nsq"}),
+		NewMetric("producer_client.connections", 2, []string{"node:localhost", "topic:orders", "client_hostname:api-1", "client_agent:go-nsq"}),
+		NewMetric("producer_client.published", 5, []string{"node:localhost", "topic:events", "client_hostname:api-1", "client_agent:go-nsq"}),
+		NewMetric("producer_client.connections", 1, []string{"node:localhost", "topic:events", "client_hostname:api-1", "client_agent:go-nsq"}),
+	}, collector.producerMetrics(newProducers()))
+}
+
+func TestCollector_producerMetrics_Counters(t *testing.T) {
+	producers := newProducers()
+
+	collector := NewCollector(producer.Producer{Hostname: "localhost"}, []*regexp.Regexp{regexp.MustCompile("producer_client.connections")})
+	collector.Counters = NewCounters()
+	assert.Empty(t, collector.producerMetrics(producers))
+
+	// The deltas of the remaining connections are summed, ignoring the
+	// connection which was closed.
+	producers[0].PubCounts[0].Count += 4
+	producers[2].PubCounts[0].Count += 2
+
+	assert.Equal(t, []Metric{
+		{Name: "producer_client.published", Rate: 1, Type: CountType, Tags: []string{"node:localhost", "topic:orders", "client_hostname:api-1", "client_agent:go-nsq"}, Value: 4},
+		{Name: "producer_client.published", Rate: 1, Type: CountType, Tags: []string{"node:localhost", "topic:events", "client_hostname:api-1", "client_agent:go-nsq"}, Value: 0},
+		{Name: "producer_client.published", Rate: 1, Type: CountType, Tags: []string{"node:localhost", "topic:orders", "client_hostname:test-api", "client_agent:pynsq"}, Value: 2},
+	}, collector.producerMetrics([]nsqd.ClientStats{producers[0], producers[2]}))
+}
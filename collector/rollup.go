@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"sort"
+	"strings"
+)
+
+// RollupPrefix prefixes the name of cluster-wide metrics.
+const RollupPrefix = "cluster."
+
+// rollupSums are the metrics summed across nodes.
+var rollupSums = map[string]bool{
+	"topic.depth":           true,
+	"topic.backend_depth":   true,
+	"channel.depth":         true,
+	"channel.backend_depth": true,
+	"channel.in_flight":     true,
+	"channel.deferred":      true,
+	"channel.clients":       true,
+}
+
+// rollupMaxes are the metrics whose maximum is taken across nodes.
+var rollupMaxes = map[string]bool{
+	"topic.e2e_processing_latency":   true,
+	"channel.e2e_processing_latency": true,
+}
+
+// Rollup returns cluster-wide metrics computed from the metrics of every node
+// of a cluster. The depth, in-flight, deferred and clients metrics of topics
+// and channels are summed, and the highest end-to-end latency is kept. Rollups
+// are named after the metric with the RollupPrefix and tagged with the tags of
+// the metric, except for the node and its version, plus the given tags.
+// Latencies reported as distributions are already aggregated by Datadog and
+// are not rolled up.
+func Rollup(metrics []Metric, tags []string) []Metric {
+	rollups := map[string]*Metric{}
+
+	for _, metric := range metrics {
+		if metric.Type != GaugeType {
+			continue
+		}
+
+		max := rollupMaxes[metric.Name]
+		if !max && !rollupSums[metric.Name] {
+			continue
+		}
+
+		rollupTags := []string{}
+		for _, tag := range metric.Tags {
+			if !strings.HasPrefix(tag, "node:") && !strings.HasPrefix(tag, "nsqd_version:") {
+				rollupTags = append(rollupTags, tag)
+			}
+		}
+
+		rollupTags = append(rollupTags, tags...)
+		key := metric.Name + "|" + strings.Join(rollupTags, ",")
+
+		rollup, ok := rollups[key]
+		if !ok {
+			created := NewMetric(RollupPrefix+metric.Name, metric.Value, rollupTags)
+			rollups[key] = &created
+			continue
+		}
+
+		switch {
+		case !max:
+			rollup.Value += metric.Value
+		case metric.Value > rollup.Value:
+			rollup.Value = metric.Value
+		}
+	}
+
+	keys := make([]string, 0, len(rollups))
+	for key := range rollups {
+		keys = append(keys, key)
+	}
+
+	// Nodes are collected concurrently, so rollups are sorted to be sent in a
+	// stable order.
+	sort.Strings(keys)
+
+	result := []Metric{}
+	for _, key := range keys {
+		result = append(result, *rollups[key])
+	}
+
+	return result
+}
@@ -0,0 +1,31 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollup(t *testing.T) {
+	metrics := []Metric{
+		NewMetric("topic.depth", 1, []string{"node:a", "topic:foo"}),
+		NewMetric("topic.depth", 2, []string{"node:b", "nsqd_version:1.2.0", "topic:foo"}),
+		NewMetric("topic.depth", 4, []string{"node:b", "topic:bar"}),
+		NewMetric("topic.paused", 1, []string{"node:a", "topic:foo"}),
+		NewMetric("channel.in_flight", 3, []string{"node:a", "topic:foo", "channel:baz"}),
+		NewMetric("channel.in_flight", 5, []string{"node:b", "topic:foo", "channel:baz"}),
+		NewMetric("channel.e2e_processing_latency", 300, []string{"node:a", "topic:foo", "channel:baz", "quantile:0.99"}),
+		NewMetric("channel.e2e_processing_latency", 200, []string{"node:b", "topic:foo", "channel:baz", "quantile:0.99"}),
+		{Name: "topic.e2e_processing_latency", Type: DistributionType, Value: 100, Tags: []string{"node:a", "topic:foo", "quantile:0.99"}},
+		{Name: "channel.depth", Type: CountType, Value: 1, Tags: []string{"node:a", "topic:foo", "channel:baz"}},
+	}
+
+	assert.Equal(t, []Metric{
+		NewMetric("cluster.channel.e2e_processing_latency", 300, []string{"topic:foo", "channel:baz", "quantile:0.99", "cluster:default"}),
+		NewMetric("cluster.channel.in_flight", 8, []string{"topic:foo", "channel:baz", "cluster:default"}),
+		NewMetric("cluster.topic.depth", 4, []string{"topic:bar", "cluster:default"}),
+		NewMetric("cluster.topic.depth", 3, []string{"topic:foo", "cluster:default"}),
+	}, Rollup(metrics, []string{"cluster:default"}))
+
+	assert.Empty(t, Rollup(nil, nil))
+}
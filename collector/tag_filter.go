@@ -0,0 +1,86 @@
+package collector
+
+import (
+	"fmt"
+
+	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
+)
+
+// FilterTags are the tags which topics, channels, clients and nodes can be
+// filtered on.
+var FilterTags = []string{"topic", "channel", "client_hostname", "node"}
+
+// TagFilter skips topics, channels, clients and nodes based on the value of
+// their tags. The zero value skips nothing.
+type TagFilter struct {
+	include []parser.TagFilter
+	exclude []parser.TagFilter
+}
+
+// NewTagFilter returns a TagFilter from "<tag>:<pattern>" strings. A value is
+// skipped when it matches any excluded pattern of its tag or, if its tag has
+// included patterns, when it matches none of them.
+func NewTagFilter(include []string, exclude []string) (TagFilter, error) {
+	var filter TagFilter
+
+	var err error
+	if filter.include, err = parseTagFilters(include); err != nil {
+		return filter, err
+	}
+
+	if filter.exclude, err = parseTagFilters(exclude); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func parseTagFilters(filters []string) ([]parser.TagFilter, error) {
+	tagFilters, err := parser.ParseTagFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filter := range tagFilters {
+		if !isFilterTag(filter.Tag) {
+			return nil, fmt.Errorf("tag %q can not be filtered on", filter.Tag)
+		}
+	}
+
+	return tagFilters, nil
+}
+
+func isFilterTag(tag string) bool {
+	for _, filterTag := range FilterTags {
+		if tag == filterTag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Skips returns whether the value of a tag is filtered out.
+func (f TagFilter) Skips(tag string, value string) bool {
+	for _, filter := range f.exclude {
+		if filter.Match(tag, value) {
+			return true
+		}
+	}
+
+	included := true
+
+	for _, filter := range f.include {
+		if filter.Tag != tag {
+			continue
+		}
+
+		if filter.Match(tag, value) {
+			return false
+		}
+
+		included = false
+	}
+
+	return !included
+}
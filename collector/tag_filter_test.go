@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagFilter_Skips(t *testing.T) {
+	assert.False(t, TagFilter{}.Skips("topic", "orders"))
+
+	filter, err := NewTagFilter([]string{"topic:orders*", "topic:events", "node:/^nsqd-[12]$/"}, []string{"channel:*#ephemeral", "topic:orders_test"})
+	assert.NoError(t, err)
+
+	var tests = []struct {
+		tag      string
+		value    string
+		expected bool
+	}{
+		{"topic", "orders", false},
+		{"topic", "orders_v1", false},
+		{"topic", "events", false},
+		{"topic", "events_v1", true},
+		{"topic", "orders_test", true},
+		{"channel", "billing", false},
+		{"channel", "billing#ephemeral", true},
+		{"client_hostname", "worker-1", false},
+		{"node", "nsqd-1", false},
+		{"node", "nsqd-3", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, filter.Skips(tt.tag, tt.value), "%s:%s", tt.tag, tt.value)
+	}
+}
+
+func TestNewTagFilter_Invalid(t *testing.T) {
+	_, err := NewTagFilter([]string{"client_id:foo"}, nil)
+	assert.EqualError(t, err, `tag "client_id" can not be filtered on`)
+
+	_, err = NewTagFilter(nil, []string{"topic"})
+	assert.EqualError(t, err, `"topic" is not in the <tag>:<pattern> format`)
+}
@@ -2,22 +2,197 @@ package dogstatsd
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/DataDog/datadog-go/statsd"
+	"github.com/DataDog/datadog-go/v5/statsd"
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/sink"
 	log "github.com/sirupsen/logrus"
 )
 
-// NewDogStatsDClient returns a preconfigured DogStatsD client with namespace and global tags.
-func NewDogStatsDClient(dogstatsdAddress string, namespace string, tags []string) (*statsd.Client, error) {
-	client, err := statsd.New(dogstatsdAddress)
+// Address prefixes of Unix domain sockets. The type of the socket is detected
+// with the unix prefix, while the unixgram and unixstream prefixes force
+// datagram and stream sockets respectively.
+const (
+	UnixPrefix       = statsd.UnixAddressPrefix
+	UnixgramPrefix   = statsd.UnixAddressDatagramPrefix
+	UnixstreamPrefix = statsd.UnixAddressStreamPrefix
+)
+
+// ClientOptions holds the buffering and socket options of a DogStatsD client.
+// Zero sizes and intervals use the defaults of the client.
+type ClientOptions struct {
+	BufferPoolSize  int
+	SenderQueueSize int
+	SocketTimeout   time.Duration
+	MaxPacketSize   int
+	FlushInterval   time.Duration
+}
+
+// DefaultClientOptions are the default options of a DogStatsD client.
+var DefaultClientOptions = ClientOptions{
+	SocketTimeout: 100 * time.Millisecond,
+	FlushInterval: 100 * time.Millisecond,
+}
+
+// newClient returns a DogStatsD client with global tags.
+func newClient(dogstatsdAddress string, tags []string, options ClientOptions) (*statsd.Client, error) {
+	for _, prefix := range []string{UnixPrefix, UnixgramPrefix, UnixstreamPrefix} {
+		if strings.HasPrefix(dogstatsdAddress, prefix) {
+			if err := checkSocket(strings.TrimPrefix(dogstatsdAddress, prefix)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultClientOptions.FlushInterval
+	}
+
+	client, err := statsd.New(dogstatsdAddress,
+		statsd.WithTags(tags),
+		statsd.WithBufferPoolSize(options.BufferPoolSize),
+		statsd.WithSenderQueueSize(options.SenderQueueSize),
+		statsd.WithWriteTimeout(options.SocketTimeout),
+		statsd.WithMaxBytesPerPayload(options.MaxPacketSize),
+		statsd.WithBufferFlushInterval(options.FlushInterval),
+		// Metrics are sent from a single goroutine, so that a single worker
+		// fills packets the most. They are already aggregated by nsqd and
+		// describe nsqd nodes rather than the container they are sent from.
+		statsd.WithWorkersCount(1),
+		statsd.WithoutClientSideAggregation(),
+		statsd.WithoutOriginDetection(),
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	client.Namespace = fmt.Sprintf("%s.", namespace)
-	client.Tags = tags
+	return client, nil
+}
 
-	log.WithFields(log.Fields{"namespace": namespace, "tags": tags}).Debug("configured dogstatsd client")
+// checkSocket returns an error unless there is a Unix domain socket at path.
+func checkSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("dogstatsd socket %s is not available - %s", path, err)
+	}
 
-	return client, nil
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("dogstatsd socket %s is not a socket", path)
+	}
+
+	return nil
+}
+
+// Sink publishes metrics through a DogStatsD client, prefixing their names with
+// its namespace.
+type Sink struct {
+	client    *statsd.Client
+	namespace string
+	shared    bool
+	stats     *clientStats
+}
+
+// clientStats holds the telemetry of a client when its stats were last
+// returned.
+type clientStats struct {
+	sync.Mutex
+	telemetry statsd.Telemetry
+}
+
+// NewDogStatsDSink returns a sink publishing metrics through a new DogStatsD
+// client with namespace and global tags. The address is either a <host>:<port>
+// to send metrics to over UDP or the path of a Unix domain socket (e.g.
+// unix:///var/run/datadog/dsd.socket), which must exist. Metrics are buffered
+// into packets of up to the maximum packet size, which are sent when full, on
+// every flush interval or when the sink is flushed.
+func NewDogStatsDSink(dogstatsdAddress string, namespace string, tags []string, options ClientOptions) (*Sink, error) {
+	client, err := newClient(dogstatsdAddress, tags, options)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"address": dogstatsdAddress, "namespace": namespace, "tags": tags}).Debug("configured dogstatsd client")
+
+	return &Sink{client: client, namespace: namespace, stats: &clientStats{}}, nil
+}
+
+// WithNamespace returns a sink publishing metrics under another namespace
+// through the same client, whose stats are shared with the sink. Closing it
+// leaves the client open, as it is still used by the sink.
+func (s *Sink) WithNamespace(namespace string) *Sink {
+	return &Sink{client: s.client, namespace: namespace, shared: true, stats: s.stats}
+}
+
+// Send publishes a batch of metrics, stopping at the first error.
+func (s *Sink) Send(metrics []collector.Metric) error {
+	for _, m := range metrics {
+		if err := s.send(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// send sends a metric using the method of the DogStatsD client matching its
+// type.
+func (s *Sink) send(metric collector.Metric) error {
+	name := metric.Name
+	if s.namespace != "" {
+		name = s.namespace + "." + name
+	}
+
+	switch metric.Type {
+	case collector.ServiceCheckType:
+		return s.client.ServiceCheck(&statsd.ServiceCheck{
+			Name:    name,
+			Status:  statsd.ServiceCheckStatus(metric.Value),
+			Message: metric.Message,
+			Tags:    metric.Tags,
+		})
+	case collector.CountType:
+		return s.client.Count(name, int64(metric.Value), metric.Tags, metric.Rate)
+	case collector.DistributionType:
+		return s.client.Distribution(name, metric.Value, metric.Tags, metric.Rate)
+	default:
+		return s.client.Gauge(name, metric.Value, metric.Tags, metric.Rate)
+	}
+}
+
+// Flush sends any buffered metrics, waiting for them to be written.
+func (s *Sink) Flush() error {
+	return s.client.Flush()
+}
+
+// Close flushes and closes the client, unless it is shared with another sink.
+func (s *Sink) Close() error {
+	if s.shared {
+		return nil
+	}
+
+	return s.client.Close()
+}
+
+// Stats returns the bytes and packets sent and dropped by the client since the
+// previous call on any sink sharing it, as counted by the telemetry of the
+// client. Packets are dropped when the sender queue is full or when they can
+// not be written to the socket.
+func (s *Sink) Stats() sink.Stats {
+	s.stats.Lock()
+	defer s.stats.Unlock()
+
+	telemetry := s.client.GetTelemetry()
+	stats := sink.Stats{
+		BytesSent:      telemetry.TotalBytesSent - s.stats.telemetry.TotalBytesSent,
+		PacketsSent:    telemetry.TotalPayloadsSent - s.stats.telemetry.TotalPayloadsSent,
+		BytesDropped:   telemetry.TotalBytesDropped - s.stats.telemetry.TotalBytesDropped,
+		PacketsDropped: telemetry.TotalPayloadsDropped - s.stats.telemetry.TotalPayloadsDropped,
+	}
+	s.stats.telemetry = telemetry
+
+	return stats
 }
@@ -1,22 +1,237 @@
 package dogstatsd_test
 
 import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
 	. "github.com/ruimarinho/nsq-dogstatsd/dogstatsd"
+	"github.com/ruimarinho/nsq-dogstatsd/sink"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestNewDogStatsdDClient(t *testing.T) {
-	client, err := NewDogStatsDClient("127.0.0.1:8125", "foobar", []string{"foo", "bar"})
+func TestNewDogStatsDSink(t *testing.T) {
+	s, err := NewDogStatsDSink("127.0.0.1:8125", "foobar", []string{"foo", "bar"}, DefaultClientOptions)
 
 	assert.Nil(t, err)
-	assert.Equal(t, client.Namespace, "foobar.")
-	assert.Equal(t, client.Tags, []string{"foo", "bar"})
+	assert.NoError(t, s.Close())
 }
 
-func TestNewDogStatsdDClient_Invalid_Address(t *testing.T) {
-	_, err := NewDogStatsDClient("foo", "foobar", []string{"foo", "bar"})
+func TestNewDogStatsDSink_Invalid_Address(t *testing.T) {
+	_, err := NewDogStatsDSink("foo", "foobar", []string{"foo", "bar"}, DefaultClientOptions)
 
 	assert.NotNil(t, err)
 }
+
+func TestSink_Send(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	s, err := NewDogStatsDSink(conn.LocalAddr().String(), "nsq", []string{"env:test"}, DefaultClientOptions)
+	assert.NoError(t, err)
+
+	defer s.Close()
+
+	var tests = []struct {
+		metric   collector.Metric
+		expected string
+	}{
+		{collector.NewMetric("topic.depth", 1, []string{"node:foo"}), "nsq.topic.depth:1|g|#env:test,node:foo"},
+		{collector.Metric{Name: "topic.messages", Type: collector.CountType, Value: 3, Rate: 1, Tags: []string{"node:foo"}}, "nsq.topic.messages:3|c|#env:test,node:foo"},
+		{collector.Metric{Name: "topic.e2e_processing_latency", Type: collector.DistributionType, Value: 1.5, Rate: 1, Tags: []string{"node:foo", "quantile:0.99"}}, "nsq.topic.e2e_processing_latency:1.5|d|#env:test,node:foo,quantile:0.99"},
+		{collector.NewServiceCheckMetric("node.health", collector.ServiceCheckCritical, "NOK - foo", []string{"node:foo"}), "_sc|nsq.node.health|2|#env:test,node:foo|m:NOK - foo"},
+	}
+
+	buffer := make([]byte, 1024)
+
+	for _, tt := range tests {
+		assert.NoError(t, s.Send([]collector.Metric{tt.metric}))
+		assert.NoError(t, s.Flush())
+
+		n, _, err := conn.ReadFrom(buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, strings.TrimSpace(string(buffer[:n])))
+	}
+}
+
+func TestSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	sink, err := NewDogStatsDSink(conn.LocalAddr().String(), "nsq", []string{}, DefaultClientOptions)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.Send([]collector.Metric{
+		collector.NewMetric("topic.depth", 1, []string{"node:foo"}),
+		collector.NewMetric("topic.backend_depth", 2, []string{"node:foo"}),
+	}))
+	assert.NoError(t, sink.Flush())
+
+	buffer := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "nsq.topic.depth:1|g|#node:foo\nnsq.topic.backend_depth:2|g|#node:foo", strings.TrimSpace(string(buffer[:n])))
+
+	assert.NoError(t, sink.Close())
+}
+
+func tempSocket(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "nsq-dogstatsd")
+	assert.NoError(t, err)
+
+	return filepath.Join(dir, "dsd.socket"), func() { os.RemoveAll(dir) }
+}
+
+func TestNewDogStatsDSink_Unixgram(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+
+	conn, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	for _, address := range []string{UnixPrefix + path, UnixgramPrefix + path} {
+		s, err := NewDogStatsDSink(address, "nsq", []string{}, ClientOptions{SocketTimeout: time.Second})
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.Send([]collector.Metric{collector.NewMetric("topic.depth", 1, []string{"node:foo"})}))
+		assert.NoError(t, s.Flush())
+
+		buffer := make([]byte, 1024)
+		n, _, err := conn.ReadFrom(buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, "nsq.topic.depth:1|g|#node:foo", strings.TrimSpace(string(buffer[:n])))
+
+		assert.NoError(t, s.Close())
+	}
+}
+
+func TestNewDogStatsDSink_Unixstream(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+
+	listener, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+
+	defer listener.Close()
+
+	s, err := NewDogStatsDSink(UnixstreamPrefix+path, "nsq", []string{}, ClientOptions{SocketTimeout: time.Second})
+	assert.NoError(t, err)
+
+	defer s.Close()
+
+	assert.NoError(t, s.Send([]collector.Metric{collector.NewMetric("topic.depth", 1, []string{"node:foo"})}))
+	assert.NoError(t, s.Flush())
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	var length uint32
+	assert.NoError(t, binary.Read(conn, binary.LittleEndian, &length))
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(conn, payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "nsq.topic.depth:1|g|#node:foo", strings.TrimSpace(string(payload)))
+}
+
+func TestNewDogStatsDSink_MissingSocket(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+
+	for _, prefix := range []string{UnixPrefix, UnixgramPrefix, UnixstreamPrefix} {
+		_, err := NewDogStatsDSink(prefix+path, "nsq", []string{}, DefaultClientOptions)
+		assert.EqualError(t, err, "dogstatsd socket "+path+" is not available - stat "+path+": no such file or directory")
+	}
+
+	assert.NoError(t, ioutil.WriteFile(path, nil, 0600))
+
+	_, err := NewDogStatsDSink(UnixPrefix+path, "nsq", []string{}, DefaultClientOptions)
+	assert.EqualError(t, err, "dogstatsd socket "+path+" is not a socket")
+}
+
+func TestSink_Stats(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+
+	conn, err := net.ListenPacket("unixgram", path)
+	assert.NoError(t, err)
+
+	s, err := NewDogStatsDSink(UnixPrefix+path, "nsq", []string{}, ClientOptions{SocketTimeout: time.Second, MaxPacketSize: 100})
+	assert.NoError(t, err)
+
+	defer s.Close()
+
+	metrics := []collector.Metric{
+		collector.NewMetric("topic.depth", 1, []string{"node:foo", "topic:foo"}),
+		collector.NewMetric("topic.depth", 2, []string{"node:foo", "topic:bar"}),
+		collector.NewMetric("topic.depth", 3, []string{"node:foo", "topic:baz"}),
+	}
+
+	// Metrics are buffered into packets of at most 100 bytes, terminated by
+	// newlines.
+	assert.NoError(t, s.Send(metrics))
+	assert.NoError(t, s.Flush())
+
+	stats := s.Stats()
+	assert.Equal(t, uint64(2), stats.PacketsSent)
+	assert.Equal(t, uint64(3*len("nsq.topic.depth:1|g|#node:foo,topic:foo\n")), stats.BytesSent)
+	assert.Zero(t, stats.PacketsDropped)
+
+	// Packets are dropped once the agent goes away.
+	conn.Close()
+	os.Remove(path)
+
+	assert.NoError(t, s.Send(metrics))
+	assert.NoError(t, s.Flush())
+
+	stats = s.Stats()
+	assert.Zero(t, stats.PacketsSent)
+	assert.Equal(t, uint64(2), stats.PacketsDropped)
+	assert.NotZero(t, stats.BytesDropped)
+
+	assert.Equal(t, sink.Stats{}, s.Stats())
+}
+
+func TestSink_WithNamespace(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	s, err := NewDogStatsDSink(conn.LocalAddr().String(), "nsq", []string{}, DefaultClientOptions)
+	assert.NoError(t, err)
+
+	defer s.Close()
+
+	// Closing the shared sink leaves the client open.
+	shared := s.WithNamespace("nsq_to_dogstatsd")
+	assert.NoError(t, shared.Close())
+
+	assert.NoError(t, shared.Send([]collector.Metric{collector.NewMetric("resolver.nodes", 1, []string{})}))
+	assert.NoError(t, s.Send([]collector.Metric{collector.NewMetric("topic.depth", 1, []string{"node:foo"})}))
+	assert.NoError(t, s.Flush())
+
+	buffer := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "nsq_to_dogstatsd.resolver.nodes:1|g\nnsq.topic.depth:1|g|#node:foo", strings.TrimSpace(string(buffer[:n])))
+
+	// Stats are returned once, by whichever sink is asked first.
+	assert.Equal(t, uint64(1), shared.Stats().PacketsSent)
+	assert.Zero(t, s.Stats().PacketsSent)
+}
@@ -3,8 +3,9 @@ module github.com/ruimarinho/nsq-dogstatsd
 go 1.13
 
 require (
-	github.com/DataDog/datadog-go v3.5.0+incompatible
+	github.com/DataDog/datadog-go/v5 v5.6.0
 	github.com/nsqio/nsq v1.2.0
-	github.com/sirupsen/logrus v1.5.0
-	github.com/stretchr/testify v1.5.1
+	github.com/sirupsen/logrus v1.7.0
+	github.com/stretchr/testify v1.8.1
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,111 @@
+package backoff
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff tracks consecutive failures per key (e.g. a nsqd node address) and
+// decides when a failing key should be retried. The delay doubles on every
+// consecutive failure, starting at the base delay and capped at the max delay.
+// Delays are measured from the start of the failed attempt, so that a key
+// failing on every interval with the interval as base delay is retried on the
+// next interval.
+type Backoff struct {
+	sync.Mutex
+	base    time.Duration
+	max     time.Duration
+	entries map[string]*entry
+}
+
+type entry struct {
+	failures int
+	retryAt  time.Time
+}
+
+// New returns a Backoff using the given base and max delays.
+func New(base time.Duration, max time.Duration) *Backoff {
+	return &Backoff{
+		base:    base,
+		max:     max,
+		entries: map[string]*entry{},
+	}
+}
+
+// Ready reports whether the key has no pending backoff and can be attempted at
+// the given time. Keys are ready up to half the base delay early, so that an
+// attempt due on an interval is not pushed to the next one by scheduling jitter.
+func (b *Backoff) Ready(key string, at time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	e, ok := b.entries[key]
+	if !ok {
+		return true
+	}
+
+	return !at.Add(b.base / 2).Before(e.retryAt)
+}
+
+// Failure records a failure of the attempt started at the given time for the
+// key and returns the number of consecutive failures along with the time after
+// which the key should be retried.
+func (b *Backoff) Failure(key string, at time.Time) (int, time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	e, ok := b.entries[key]
+	if !ok {
+		e = &entry{}
+		b.entries[key] = e
+	}
+
+	e.failures++
+	e.retryAt = at.Add(b.delay(e.failures))
+
+	return e.failures, e.retryAt
+}
+
+// Success clears any failures recorded for the key.
+func (b *Backoff) Success(key string) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.entries, key)
+}
+
+// Retain forgets the failures of any key other than the given ones, such as
+// nodes which left the cluster.
+func (b *Backoff) Retain(keys []string) {
+	b.Lock()
+	defer b.Unlock()
+
+	retained := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		retained[key] = true
+	}
+
+	for key := range b.entries {
+		if !retained[key] {
+			delete(b.entries, key)
+		}
+	}
+}
+
+func (b *Backoff) delay(failures int) time.Duration {
+	delay := b.base
+
+	for i := 1; i < failures; i++ {
+		delay *= 2
+
+		if delay >= b.max {
+			return b.max
+		}
+	}
+
+	if delay > b.max {
+		return b.max
+	}
+
+	return delay
+}
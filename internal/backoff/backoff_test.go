@@ -0,0 +1,76 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Failure(t *testing.T) {
+	now := time.Unix(1515289281, 0)
+
+	b := New(10*time.Second, 30*time.Second)
+
+	var tests = []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 30 * time.Second},
+		{4, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		failures, retryAt := b.Failure("foo", now)
+
+		assert.Equal(t, tt.failures, failures)
+		assert.Equal(t, now.Add(tt.delay), retryAt)
+	}
+}
+
+func TestBackoff_Ready(t *testing.T) {
+	now := time.Unix(1515289281, 0)
+
+	b := New(10*time.Second, time.Minute)
+
+	assert.True(t, b.Ready("foo", now))
+
+	b.Failure("foo", now)
+	assert.False(t, b.Ready("foo", now))
+	assert.True(t, b.Ready("bar", now))
+
+	// The next interval may start slightly before the retry time.
+	assert.True(t, b.Ready("foo", now.Add(10*time.Second-time.Millisecond)))
+	assert.False(t, b.Ready("foo", now.Add(4*time.Second)))
+}
+
+func TestBackoff_Success(t *testing.T) {
+	now := time.Unix(1515289281, 0)
+
+	b := New(time.Minute, time.Hour)
+
+	b.Failure("foo", now)
+	assert.False(t, b.Ready("foo", now))
+
+	b.Success("foo")
+	assert.True(t, b.Ready("foo", now))
+}
+
+func TestBackoff_Retain(t *testing.T) {
+	now := time.Unix(1515289281, 0)
+
+	b := New(time.Minute, time.Hour)
+
+	b.Failure("foo", now)
+	b.Failure("bar", now)
+	b.Retain([]string{"bar", "baz"})
+
+	assert.True(t, b.Ready("foo", now))
+	assert.False(t, b.Ready("bar", now))
+
+	// A key failing again after being forgotten starts over at the base delay.
+	failures, _ := b.Failure("foo", now)
+	assert.Equal(t, 1, failures)
+}
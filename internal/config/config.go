@@ -0,0 +1,345 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/dogstatsd"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/checker"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
+	"github.com/ruimarinho/nsq-dogstatsd/prometheus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Error policies.
+const (
+	ErrorPolicyFailFast = "fail-fast"
+	ErrorPolicyTolerate = "tolerate"
+)
+
+// Sinks.
+const (
+	SinkDogStatsD  = "dogstatsd"
+	SinkPrometheus = "prometheus"
+	SinkStdout     = "stdout"
+	SinkFilePrefix = "file:"
+)
+
+// Config holds every setting that can be given as a flag, plus the clusters to
+// collect metrics from.
+type Config struct {
+	Interval                 time.Duration `yaml:"interval"`
+	ResolveInterval          time.Duration `yaml:"resolve_interval"`
+	Namespace                string        `yaml:"namespace"`
+	DogStatsDAddress         string        `yaml:"dogstatsd_address"`
+	DogStatsDBufferPoolSize  int           `yaml:"dogstatsd_buffer_pool_size"`
+	DogStatsDSenderQueueSize int           `yaml:"dogstatsd_sender_queue_size"`
+	DogStatsDSocketTimeout   time.Duration `yaml:"dogstatsd_socket_timeout"`
+	DogStatsDMaxPacketSize   int           `yaml:"dogstatsd_max_packet_size"`
+	DogStatsDFlushInterval   time.Duration `yaml:"dogstatsd_flush_interval"`
+	ErrorPolicy              string        `yaml:"error_policy"`
+	MaxBackoff               time.Duration `yaml:"max_backoff"`
+	Sinks                    []string      `yaml:"sinks"`
+	PrometheusAddress        string        `yaml:"prometheus_address"`
+	PrometheusCollection     string        `yaml:"prometheus_collection"`
+	ExcludeMetrics           []string      `yaml:"exclude_metrics"`
+	IncludeMetrics           []string      `yaml:"include_metrics"`
+	IncludeTags              []string      `yaml:"include_tags"`
+	ExcludeTags              []string      `yaml:"exclude_tags"`
+	IncludeTopics            []string      `yaml:"include_topics"`
+	IncludeChannels          []string      `yaml:"include_channels"`
+	IncludeClients           bool          `yaml:"include_clients"`
+	ClientMetrics            string        `yaml:"client_metrics"`
+	ClientTags               []string      `yaml:"client_tags"`
+	MaxClientTagSets         int           `yaml:"max_client_tag_sets"`
+	LatencyUnit              string        `yaml:"latency_unit"`
+	LatencyDistribution      bool          `yaml:"latency_distribution"`
+	NSQDVersionTag           bool          `yaml:"nsqd_version_tag"`
+	ClusterRollups           bool          `yaml:"cluster_rollups"`
+	NSQDHTTPAddresses        []string      `yaml:"nsqd_http_addresses"`
+	LookupdHTTPAddresses     []string      `yaml:"lookupd_http_addresses"`
+	Tags                     []string      `yaml:"tags"`
+	Verbose                  int           `yaml:"verbose"`
+	Telemetry                bool          `yaml:"telemetry"`
+	HTTPAddress              string        `yaml:"http_address"`
+	ReadyIntervals           int           `yaml:"ready_intervals"`
+	TLSCert                  string        `yaml:"tls_cert"`
+	TLSKey                   string        `yaml:"tls_key"`
+	TLSRootCAFile            string        `yaml:"tls_root_ca_file"`
+	TLSMinVersion            string        `yaml:"tls_min_version"`
+	ConnectTimeout           time.Duration `yaml:"connect_timeout"`
+	RequestTimeout           time.Duration `yaml:"request_timeout"`
+	MaxRetries               int           `yaml:"max_retries"`
+	RetryBackoff             time.Duration `yaml:"retry_backoff"`
+	Clusters                 []Cluster     `yaml:"clusters"`
+}
+
+// Cluster holds the settings of a NSQ cluster. Its namespace defaults to the
+// global namespace and its tags are added to the global tags.
+type Cluster struct {
+	Name                 string   `yaml:"name"`
+	NSQDHTTPAddresses    []string `yaml:"nsqd_http_addresses"`
+	LookupdHTTPAddresses []string `yaml:"lookupd_http_addresses"`
+	Namespace            string   `yaml:"namespace"`
+	Tags                 []string `yaml:"tags"`
+}
+
+// Load reads the YAML configuration file at path on top of the given config,
+// so that settings missing from the file keep their existing values.
+func Load(path string, config Config) (Config, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.UnmarshalStrict(body, &config); err != nil {
+		return config, fmt.Errorf("%s - %s", path, err)
+	}
+
+	return config, nil
+}
+
+// GetClusters returns the clusters to collect metrics from. The global nsqd and
+// nsqlookupd addresses, if any, form an unnamed cluster. Named clusters inherit
+// the global namespace and tags, and are tagged with their name.
+func (c Config) GetClusters() []Cluster {
+	clusters := []Cluster{}
+
+	if len(c.NSQDHTTPAddresses) > 0 || len(c.LookupdHTTPAddresses) > 0 {
+		clusters = append(clusters, Cluster{
+			NSQDHTTPAddresses:    c.NSQDHTTPAddresses,
+			LookupdHTTPAddresses: c.LookupdHTTPAddresses,
+			Namespace:            c.Namespace,
+			Tags:                 c.Tags,
+		})
+	}
+
+	for _, cluster := range c.Clusters {
+		if cluster.Namespace == "" {
+			cluster.Namespace = c.Namespace
+		}
+
+		tags := append([]string{}, c.Tags...)
+		if cluster.Name != "" {
+			tags = append(tags, fmt.Sprintf("cluster:%s", cluster.Name))
+		}
+
+		cluster.Tags = append(tags, cluster.Tags...)
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
+
+// GetSinks returns the sinks to send metrics to. Without any sink, metrics
+// are sent to dogstatsd or, if a prometheus address is set, served to
+// prometheus instead.
+func (c Config) GetSinks() []string {
+	if len(c.Sinks) > 0 {
+		return c.Sinks
+	}
+
+	if c.PrometheusAddress != "" {
+		return []string{SinkPrometheus}
+	}
+
+	return []string{SinkDogStatsD}
+}
+
+// CollectsOnScrape returns whether metrics are only collected when scraped by
+// Prometheus, rather than once or on every interval.
+func (c Config) CollectsOnScrape() bool {
+	for _, sink := range c.GetSinks() {
+		if sink == SinkPrometheus && c.PrometheusCollection == prometheus.CollectOnScrape {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UsesTLS returns whether nsqd and nsqlookupd are queried over HTTPS, which is
+// the case when any TLS setting is given.
+func (c Config) UsesTLS() bool {
+	return c.TLSCert != "" || c.TLSKey != "" || c.TLSRootCAFile != "" || c.TLSMinVersion != ""
+}
+
+// GetClientOptions returns the timeouts and retries used to query nsqd and
+// nsqlookupd.
+func (c Config) GetClientOptions() fetcher.ClientOptions {
+	return fetcher.ClientOptions{
+		ConnectTimeout: c.ConnectTimeout,
+		RequestTimeout: c.RequestTimeout,
+		MaxRetries:     c.MaxRetries,
+		RetryBackoff:   c.RetryBackoff,
+	}
+}
+
+// GetDogStatsDOptions returns the buffering, batching and socket options of
+// DogStatsD clients.
+func (c Config) GetDogStatsDOptions() dogstatsd.ClientOptions {
+	return dogstatsd.ClientOptions{
+		BufferPoolSize:  c.DogStatsDBufferPoolSize,
+		SenderQueueSize: c.DogStatsDSenderQueueSize,
+		SocketTimeout:   c.DogStatsDSocketTimeout,
+		MaxPacketSize:   c.DogStatsDMaxPacketSize,
+		FlushInterval:   c.DogStatsDFlushInterval,
+	}
+}
+
+// Validate checks whether the configuration is valid.
+func (c Config) Validate() error {
+	clusters := c.GetClusters()
+	if len(clusters) == 0 {
+		return errors.New("--lookup-http-address or --nsqd-http-address must be provided at least once")
+	}
+
+	names := map[string]bool{}
+
+	for _, cluster := range clusters {
+		if len(cluster.NSQDHTTPAddresses) == 0 && len(cluster.LookupdHTTPAddresses) == 0 {
+			return fmt.Errorf("cluster %q must have at least one nsqd or nsqlookupd address", cluster.Name)
+		}
+
+		if names[cluster.Name] {
+			return fmt.Errorf("cluster %q is defined more than once", cluster.Name)
+		}
+
+		names[cluster.Name] = true
+
+		if err := checker.CheckAddresses(cluster.NSQDHTTPAddresses); err != nil {
+			return fmt.Errorf("--nsqd-http-address - %s", err)
+		}
+
+		if err := checker.CheckAddresses(cluster.LookupdHTTPAddresses); err != nil {
+			return fmt.Errorf("--nsqlookupd-http-address - %s", err)
+		}
+	}
+
+	if c.ErrorPolicy != ErrorPolicyTolerate && c.ErrorPolicy != ErrorPolicyFailFast {
+		return fmt.Errorf("--error-policy must be either %q or %q", ErrorPolicyTolerate, ErrorPolicyFailFast)
+	}
+
+	if c.PrometheusCollection != prometheus.CollectOnScrape && c.PrometheusCollection != prometheus.CollectOnInterval {
+		return fmt.Errorf("--prometheus-collection must be either %q or %q", prometheus.CollectOnScrape, prometheus.CollectOnInterval)
+	}
+
+	for _, sink := range c.GetSinks() {
+		if sink != SinkDogStatsD && sink != SinkPrometheus && sink != SinkStdout && !strings.HasPrefix(sink, SinkFilePrefix) {
+			return fmt.Errorf("--sink %q is unknown", sink)
+		}
+
+		if sink == SinkPrometheus && c.PrometheusAddress == "" {
+			return errors.New("--sink prometheus requires --prometheus-address to be set")
+		}
+
+		if sink == SinkPrometheus && c.PrometheusCollection == prometheus.CollectOnInterval && c.Interval.Seconds() == 0 {
+			return fmt.Errorf("--prometheus-collection %q requires --interval to be set", prometheus.CollectOnInterval)
+		}
+	}
+
+	if c.HTTPAddress != "" {
+		if c.Interval.Seconds() == 0 && !c.CollectsOnScrape() {
+			return errors.New("--http-address requires --interval to be set")
+		}
+
+		if c.HTTPAddress == c.PrometheusAddress {
+			return errors.New("--http-address must be different from --prometheus-address")
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("--tls-cert and --tls-key must be provided together")
+	}
+
+	if _, ok := fetcher.TLSVersions[c.TLSMinVersion]; c.TLSMinVersion != "" && !ok {
+		return errors.New("--tls-min-version must be one of 1.0, 1.1, 1.2 or 1.3")
+	}
+
+	if c.ConnectTimeout < 0 || c.RequestTimeout < 0 {
+		return errors.New("--connect-timeout and --request-timeout must not be negative")
+	}
+
+	if c.MaxRetries < 0 || c.RetryBackoff < 0 {
+		return errors.New("--max-retries and --retry-backoff must not be negative")
+	}
+
+	if c.MaxBackoff <= 0 {
+		return errors.New("--max-backoff must be positive")
+	}
+
+	if c.DogStatsDBufferPoolSize < 0 || c.DogStatsDSenderQueueSize < 0 || c.DogStatsDSocketTimeout < 0 {
+		return errors.New("--dogstatsd-buffer-pool-size, --dogstatsd-sender-queue-size and --dogstatsd-socket-timeout must not be negative")
+	}
+
+	if c.DogStatsDMaxPacketSize < 0 {
+		return errors.New("--dogstatsd-max-packet-size must not be negative")
+	}
+
+	if c.DogStatsDFlushInterval <= 0 {
+		return errors.New("--dogstatsd-flush-interval must be positive")
+	}
+
+	if c.ReadyIntervals < 1 {
+		return errors.New("--ready-intervals must be at least 1")
+	}
+
+	if _, err := parser.Parse(c.ExcludeMetrics); err != nil {
+		return fmt.Errorf("--exclude-metrics contains invalid regexp - %s", err)
+	}
+
+	if _, err := parser.ParseMetricFilters(c.IncludeMetrics); err != nil {
+		return fmt.Errorf("--include-metrics contains invalid pattern - %s", err)
+	}
+
+	if _, err := collector.NewTagFilter(c.IncludeTags, c.ExcludeTags); err != nil {
+		return fmt.Errorf("--include-tag and --exclude-tag must be <tag>:<pattern> filters on %s - %s", strings.Join(collector.FilterTags, ", "), err)
+	}
+
+	if _, err := parser.ParseNames(c.IncludeTopics); err != nil {
+		return fmt.Errorf("--include-topic contains invalid regexp - %s", err)
+	}
+
+	if _, err := parser.ParseNames(c.IncludeChannels); err != nil {
+		return fmt.Errorf("--include-channel contains invalid regexp - %s", err)
+	}
+
+	if !contains(collector.ClientMetricsModes, c.ClientMetrics) {
+		return fmt.Errorf("--client-metrics must be one of %s", strings.Join(collector.ClientMetricsModes, ", "))
+	}
+
+	for _, tag := range c.ClientTags {
+		if !contains(collector.ClientTags, tag) {
+			return fmt.Errorf("--client-tag must be one of %s", strings.Join(collector.ClientTags, ", "))
+		}
+	}
+
+	if !contains(collector.LatencyUnits, c.LatencyUnit) {
+		return fmt.Errorf("--latency-unit must be one of %s", strings.Join(collector.LatencyUnits, ", "))
+	}
+
+	if c.MaxClientTagSets < 0 {
+		return errors.New("--max-client-tag-sets must not be negative")
+	}
+
+	if c.Verbose < 0 || c.Verbose > 3 {
+		return errors.New("--verbose is outside valid range (0-3)")
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,166 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/ruimarinho/nsq-dogstatsd/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, body string) (string, func()) {
+	dir, err := ioutil.TempDir("", "nsq-dogstatsd")
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(body), 0644))
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func validConfig() Config {
+	return Config{
+		Namespace:              "nsq",
+		DogStatsDAddress:       "127.0.0.1:8125",
+		ErrorPolicy:            ErrorPolicyTolerate,
+		PrometheusCollection:   "scrape",
+		NSQDHTTPAddresses:      []string{"127.0.0.1:4151"},
+		ReadyIntervals:         3,
+		ClientMetrics:          "all",
+		LatencyUnit:            "ns",
+		DogStatsDFlushInterval: 100 * time.Millisecond,
+		MaxBackoff:             5 * time.Minute,
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path, cleanup := writeConfig(t, `
+interval: 10s
+tags:
+  - environment:production
+clusters:
+  - name: east
+    lookupd_http_addresses:
+      - 10.0.0.1:4161
+    tags:
+      - region:us-east-1
+  - name: west
+    namespace: nsq_west
+    nsqd_http_addresses:
+      - 10.1.0.1:4151
+`)
+	defer cleanup()
+
+	config, err := Load(path, Config{Namespace: "nsq", DogStatsDAddress: "127.0.0.1:8125"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 10*time.Second, config.Interval)
+	assert.Equal(t, "127.0.0.1:8125", config.DogStatsDAddress)
+	assert.Equal(t, []Cluster{
+		{
+			Name:                 "east",
+			LookupdHTTPAddresses: []string{"10.0.0.1:4161"},
+			Namespace:            "nsq",
+			Tags:                 []string{"environment:production", "cluster:east", "region:us-east-1"},
+		},
+		{
+			Name:              "west",
+			NSQDHTTPAddresses: []string{"10.1.0.1:4151"},
+			Namespace:         "nsq_west",
+			Tags:              []string{"environment:production", "cluster:west"},
+		},
+	}, config.GetClusters())
+}
+
+func TestLoad_UnknownField(t *testing.T) {
+	path, cleanup := writeConfig(t, "foo: bar\n")
+	defer cleanup()
+
+	_, err := Load(path, Config{})
+	assert.Error(t, err)
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	_, err := Load("/nonexistent/config.yaml", Config{})
+	assert.Error(t, err)
+}
+
+func TestGetClusters_Global(t *testing.T) {
+	config := Config{Namespace: "nsq", Tags: []string{"foo"}, NSQDHTTPAddresses: []string{"127.0.0.1:4151"}}
+
+	assert.Equal(t, []Cluster{{NSQDHTTPAddresses: []string{"127.0.0.1:4151"}, Namespace: "nsq", Tags: []string{"foo"}}}, config.GetClusters())
+}
+
+func TestGetSinks(t *testing.T) {
+	assert.Equal(t, []string{SinkDogStatsD}, Config{}.GetSinks())
+	assert.Equal(t, []string{SinkPrometheus}, Config{PrometheusAddress: ":9117"}.GetSinks())
+	assert.Equal(t, []string{SinkStdout}, Config{PrometheusAddress: ":9117", Sinks: []string{SinkStdout}}.GetSinks())
+}
+
+func TestCollectsOnScrape(t *testing.T) {
+	assert.False(t, Config{PrometheusCollection: "scrape"}.CollectsOnScrape())
+	assert.True(t, Config{PrometheusAddress: ":9117", PrometheusCollection: "scrape"}.CollectsOnScrape())
+	assert.False(t, Config{PrometheusAddress: ":9117", PrometheusCollection: "cache"}.CollectsOnScrape())
+}
+
+func TestUsesTLS(t *testing.T) {
+	assert.False(t, Config{}.UsesTLS())
+	assert.True(t, Config{TLSMinVersion: "1.2"}.UsesTLS())
+	assert.True(t, Config{TLSRootCAFile: "ca.pem"}.UsesTLS())
+	assert.True(t, Config{TLSCert: "cert.pem", TLSKey: "key.pem"}.UsesTLS())
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+
+	var tests = []struct {
+		mutate   func(c *Config)
+		expected string
+	}{
+		{func(c *Config) { c.NSQDHTTPAddresses = nil }, "--lookup-http-address or --nsqd-http-address must be provided at least once"},
+		{func(c *Config) { c.Clusters = []Cluster{{Name: "foo"}} }, `cluster "foo" must have at least one nsqd or nsqlookupd address`},
+		{func(c *Config) {
+			c.Clusters = []Cluster{{Name: "foo", NSQDHTTPAddresses: []string{"foo"}}, {Name: "foo", NSQDHTTPAddresses: []string{"bar"}}}
+		}, `cluster "foo" is defined more than once`},
+		{func(c *Config) { c.NSQDHTTPAddresses = []string{"http://127.0.0.1:4151"} }, "--nsqd-http-address - address should not contain http scheme"},
+		{func(c *Config) { c.LookupdHTTPAddresses = []string{"http://127.0.0.1:4161"} }, "--nsqlookupd-http-address - address should not contain http scheme"},
+		{func(c *Config) { c.ErrorPolicy = "foo" }, `--error-policy must be either "tolerate" or "fail-fast"`},
+		{func(c *Config) { c.PrometheusCollection = "foo" }, `--prometheus-collection must be either "scrape" or "cache"`},
+		{func(c *Config) { c.Sinks = []string{"foo"} }, `--sink "foo" is unknown`},
+		{func(c *Config) { c.Sinks = []string{SinkPrometheus} }, "--sink prometheus requires --prometheus-address to be set"},
+		{func(c *Config) { c.PrometheusAddress = ":9117"; c.PrometheusCollection = "cache" }, `--prometheus-collection "cache" requires --interval to be set`},
+		{func(c *Config) { c.ExcludeMetrics = []string{"*"} }, "--exclude-metrics contains invalid regexp - error parsing regexp: missing argument to repetition operator: `*`"},
+		{func(c *Config) { c.HTTPAddress = ":8080" }, "--http-address requires --interval to be set"},
+		{func(c *Config) { c.Interval = time.Second; c.HTTPAddress = ":9117"; c.PrometheusAddress = ":9117" }, "--http-address must be different from --prometheus-address"},
+		{func(c *Config) { c.TLSCert = "cert.pem" }, "--tls-cert and --tls-key must be provided together"},
+		{func(c *Config) { c.TLSKey = "key.pem" }, "--tls-cert and --tls-key must be provided together"},
+		{func(c *Config) { c.TLSMinVersion = "1.4" }, "--tls-min-version must be one of 1.0, 1.1, 1.2 or 1.3"},
+		{func(c *Config) { c.RequestTimeout = -time.Second }, "--connect-timeout and --request-timeout must not be negative"},
+		{func(c *Config) { c.MaxRetries = -1 }, "--max-retries and --retry-backoff must not be negative"},
+		{func(c *Config) { c.MaxBackoff = 0 }, "--max-backoff must be positive"},
+		{func(c *Config) { c.MaxBackoff = -time.Second }, "--max-backoff must be positive"},
+		{func(c *Config) { c.ReadyIntervals = 0 }, "--ready-intervals must be at least 1"},
+		{func(c *Config) { c.IncludeMetrics = []string{"*"} }, "--include-metrics contains invalid pattern - error parsing regexp: missing argument to repetition operator: `*`"},
+		{func(c *Config) { c.ExcludeTags = []string{"client_id:foo"} }, `--include-tag and --exclude-tag must be <tag>:<pattern> filters on topic, channel, client_hostname, node - tag "client_id" can not be filtered on`},
+		{func(c *Config) { c.DogStatsDSocketTimeout = -time.Second }, "--dogstatsd-buffer-pool-size, --dogstatsd-sender-queue-size and --dogstatsd-socket-timeout must not be negative"},
+		{func(c *Config) { c.DogStatsDMaxPacketSize = -1 }, "--dogstatsd-max-packet-size must not be negative"},
+		{func(c *Config) { c.DogStatsDFlushInterval = 0 }, "--dogstatsd-flush-interval must be positive"},
+		{func(c *Config) { c.ClientMetrics = "foo" }, "--client-metrics must be one of all, sum, max, hostname, agent, none"},
+		{func(c *Config) { c.ClientTags = []string{"topic"} }, "--client-tag must be one of client_id, client_agent, client_hostname, client_address"},
+		{func(c *Config) { c.LatencyUnit = "us" }, "--latency-unit must be one of ns, ms, s"},
+		{func(c *Config) { c.MaxClientTagSets = -1 }, "--max-client-tag-sets must not be negative"},
+		{func(c *Config) { c.IncludeTopics = []string{"*"} }, "--include-topic contains invalid regexp - error parsing regexp: missing argument to repetition operator: `*`"},
+		{func(c *Config) { c.IncludeChannels = []string{"*"} }, "--include-channel contains invalid regexp - error parsing regexp: missing argument to repetition operator: `*`"},
+		{func(c *Config) { c.Verbose = 4 }, "--verbose is outside valid range (0-3)"},
+	}
+
+	for _, tt := range tests {
+		config := validConfig()
+		tt.mutate(&config)
+
+		assert.EqualError(t, config.Validate(), tt.expected)
+	}
+}
@@ -1,9 +1,16 @@
 package fetcher
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 // Fetcher fetches the content of a URL.
@@ -13,30 +20,178 @@ type Fetcher interface {
 	SetBaseURL(address string)
 }
 
+// StatusError is returned when a node responds with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("response code was %d", e.StatusCode)
+}
+
+// Error is returned when a resource can not be fetched from a node, naming the
+// node and the number of attempts made.
+type Error struct {
+	Address  string
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	err := e.Err
+
+	// The URL is left out as the node is already named.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	if e.Attempts > 1 {
+		return fmt.Sprintf("%s - %s (after %d attempts)", e.Address, err, e.Attempts)
+	}
+
+	return fmt.Sprintf("%s - %s", e.Address, err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClientOptions holds the timeouts and retries of a Client. Zero timeouts mean
+// no timeout.
+type ClientOptions struct {
+	ConnectTimeout time.Duration
+	RequestTimeout time.Duration
+	MaxRetries     int
+	RetryBackoff   time.Duration
+}
+
+// DefaultClientOptions are the options of DefaultClient.
+var DefaultClientOptions = ClientOptions{
+	ConnectTimeout: 5 * time.Second,
+	RequestTimeout: 30 * time.Second,
+	MaxRetries:     2,
+	RetryBackoff:   100 * time.Millisecond,
+}
+
+// Client creates fetchers sharing the same HTTP client, whose connections are
+// kept alive and reused across fetches.
+type Client struct {
+	HTTPClient   *http.Client
+	Scheme       string
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// DefaultClient fetches resources over plain HTTP using the default options.
+var DefaultClient = NewClient(nil, DefaultClientOptions)
+
+// NewClient returns a Client fetching resources over HTTPS with the given TLS
+// configuration or, if it is nil, over plain HTTP.
+func NewClient(tlsConfig *tls.Config, options ClientOptions) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: options.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = options.ConnectTimeout
+	transport.TLSClientConfig = tlsConfig
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+
+	return &Client{
+		HTTPClient:   &http.Client{Transport: transport, Timeout: options.RequestTimeout},
+		Scheme:       scheme,
+		MaxRetries:   options.MaxRetries,
+		RetryBackoff: options.RetryBackoff,
+	}
+}
+
+// NewFetcher instantiates a NSQDFetcher using the client and sets its base URL.
+func (c *Client) NewFetcher(address string) Fetcher {
+	fetcher := &NSQDFetcher{client: c}
+	fetcher.SetBaseURL(address)
+
+	return fetcher
+}
+
+// UsesTLS returns whether resources are fetched over HTTPS.
+func (c *Client) UsesTLS() bool {
+	return c.Scheme == "https"
+}
+
+// Backoff returns how long to wait before the given retry, doubling on every
+// retry with a random jitter so that nodes are not retried in lockstep.
+func (c *Client) Backoff(retry int) time.Duration {
+	delay := c.RetryBackoff << uint(retry-1)
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
 // NSQDFetcher holds the baseURL to the nsqd node which includes the HTTP scheme.
 type NSQDFetcher struct {
 	baseURL string
+	address string
+	client  *Client
 }
 
-// Fetch retrieves data from a remote resource.
+// Fetch retrieves data from a remote resource. Network errors and server errors
+// are retried up to the maximum number of retries of the client.
 func (f NSQDFetcher) Fetch(path string) ([]byte, error) {
-	response, err := http.Get(f.GetURL(path))
+	client := f.client
+	if client == nil {
+		client = DefaultClient
+	}
+
+	request, err := http.NewRequest(http.MethodGet, f.GetURL(path), nil)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Address: f.address, Attempts: 1, Err: err}
 	}
 
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("response code was %d", response.StatusCode)
+	attempts := 0
+
+	for {
+		attempts++
+
+		body, retry, err := f.fetch(client.HTTPClient, request)
+		if err == nil {
+			return body, nil
+		}
+
+		if !retry || attempts > client.MaxRetries {
+			return nil, &Error{Address: f.address, Attempts: attempts, Err: err}
+		}
+
+		time.Sleep(client.Backoff(attempts))
+	}
+}
+
+// fetch makes a single request, returning whether it is worth retrying if it
+// failed.
+func (f NSQDFetcher) fetch(client *http.Client, request *http.Request) ([]byte, bool, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, true, err
 	}
 
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		// Drain the body so that the connection can be reused.
+		io.Copy(ioutil.Discard, response.Body)
+
+		return nil, response.StatusCode >= 500, StatusError{StatusCode: response.StatusCode}
+	}
+
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 
-	return body, nil
+	return body, false, nil
 }
 
 // GetURL returns the URL constructed by joining the base URL with the given path.
@@ -46,13 +201,17 @@ func (f NSQDFetcher) GetURL(path string) string {
 
 // SetBaseURL sets the base URL for the remote resource.
 func (f *NSQDFetcher) SetBaseURL(address string) {
-	f.baseURL = fmt.Sprintf("http://%s", address)
+	scheme := "http"
+	if f.client != nil {
+		scheme = f.client.Scheme
+	}
+
+	f.address = address
+	f.baseURL = fmt.Sprintf("%s://%s", scheme, address)
 }
 
-// NewFetcher instantiates a NSQDFetcher and sets its base URL.
+// NewFetcher instantiates a NSQDFetcher using the default client and sets its
+// base URL.
 func NewFetcher(address string) Fetcher {
-	fetcher := &NSQDFetcher{}
-	fetcher.SetBaseURL(address)
-
-	return fetcher
+	return DefaultClient.NewFetcher(address)
 }
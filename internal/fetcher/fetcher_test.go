@@ -1,10 +1,13 @@
 package fetcher_test
 
 import (
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	. "github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
 	"github.com/stretchr/testify/assert"
@@ -38,7 +41,95 @@ func TestFetcher_Fetch_invalidStatusCode(t *testing.T) {
 	fetcher := NewFetcher(nsqdURL.Host)
 	_, err := fetcher.Fetch("")
 
-	assert.EqualError(t, err, "response code was 500")
+	assert.EqualError(t, err, nsqdURL.Host+" - response code was 500 (after 3 attempts)")
+	assert.True(t, errors.As(err, &StatusError{}))
+}
+
+func TestFetcher_Fetch_retries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			w.Write([]byte(`{"status_code": 200}`))
+		}))
+
+	defer server.Close()
+
+	nsqdURL, parseErr := url.Parse(server.URL)
+	assert.NoError(t, parseErr)
+
+	client := NewClient(nil, ClientOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	body, err := client.NewFetcher(nsqdURL.Host).Fetch("")
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status_code": 200}`, string(body))
+	assert.Equal(t, 3, requests)
+
+	// Client errors are not retried.
+	requests = 0
+	client = NewClient(nil, ClientOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	_, err = client.NewFetcher(nsqdURL.Host).Fetch("%zz")
+
+	assert.EqualError(t, err, nsqdURL.Host+` - invalid URL escape "%zz"`)
+	assert.Equal(t, 0, requests)
+}
+
+func TestFetcher_Fetch_clientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusNotFound)
+		}))
+
+	defer server.Close()
+
+	nsqdURL, parseErr := url.Parse(server.URL)
+	assert.NoError(t, parseErr)
+
+	client := NewClient(nil, ClientOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	_, err := client.NewFetcher(nsqdURL.Host).Fetch("")
+
+	assert.EqualError(t, err, nsqdURL.Host+" - response code was 404")
+	assert.Equal(t, 1, requests)
+}
+
+func TestFetcher_Fetch_timeout(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+
+	defer server.Close()
+
+	nsqdURL, parseErr := url.Parse(server.URL)
+	assert.NoError(t, parseErr)
+
+	client := NewClient(nil, ClientOptions{RequestTimeout: 10 * time.Millisecond})
+	_, err := client.NewFetcher(nsqdURL.Host).Fetch("")
+
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+	assert.Contains(t, err.Error(), nsqdURL.Host+" - ")
+}
+
+func TestClient_backoff(t *testing.T) {
+	client := NewClient(nil, ClientOptions{RetryBackoff: 100 * time.Millisecond})
+
+	for retry, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		delay := client.Backoff(retry + 1)
+
+		assert.True(t, delay >= max/2 && delay <= max, "retry %d waited %s", retry+1, delay)
+	}
+
+	assert.Zero(t, NewClient(nil, ClientOptions{}).Backoff(1))
 }
 
 func TestFetcher_Fetch(t *testing.T) {
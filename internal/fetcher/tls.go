@@ -0,0 +1,58 @@
+package fetcher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSVersions maps the supported minimum TLS versions to their identifiers.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig returns a TLS configuration presenting the given client
+// certificate, if any, and trusting the given root CA file instead of the
+// system roots, if any. An empty minimum version defaults to TLS 1.2.
+func NewTLSConfig(certFile string, keyFile string, rootCAFile string, minVersion string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if minVersion != "" {
+		version, ok := TLSVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", minVersion)
+		}
+
+		config.MinVersion = version
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if rootCAFile != "" {
+		pem, err := ioutil.ReadFile(rootCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(rootCAFile + " does not contain any PEM certificate")
+		}
+
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
@@ -0,0 +1,115 @@
+package fetcher_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes the certificate and key of a TLS test server as PEM
+// files, returning their paths.
+func writeCertificate(t *testing.T, server *httptest.Server) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "nsq-dogstatsd")
+	assert.NoError(t, err)
+
+	cert := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+
+	return certFile, keyFile, func() { os.RemoveAll(dir) }
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	certFile, keyFile, cleanup := writeCertificate(t, server)
+	defer cleanup()
+
+	config, err := NewTLSConfig(certFile, keyFile, certFile, "1.2")
+	assert.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+	assert.NotNil(t, config.RootCAs)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	config, err = NewTLSConfig("", "", "", "")
+	assert.NoError(t, err)
+	assert.Empty(t, config.Certificates)
+	assert.Nil(t, config.RootCAs)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+
+	config, err = NewTLSConfig("", "", "", "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS10), config.MinVersion)
+}
+
+func TestNewTLSConfig_errors(t *testing.T) {
+	_, err := NewTLSConfig("", "", "", "1.4")
+	assert.EqualError(t, err, `unknown TLS version "1.4"`)
+
+	_, err = NewTLSConfig("/nonexistent/cert.pem", "/nonexistent/key.pem", "", "")
+	assert.Error(t, err)
+
+	_, err = NewTLSConfig("", "", "/nonexistent/ca.pem", "")
+	assert.Error(t, err)
+
+	file, err := ioutil.TempFile("", "nsq-dogstatsd")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	_, err = NewTLSConfig("", "", file.Name(), "")
+	assert.EqualError(t, err, file.Name()+" does not contain any PEM certificate")
+}
+
+func TestClient_Fetch_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status_code": 200}`))
+		}))
+
+	defer server.Close()
+
+	certFile, _, cleanup := writeCertificate(t, server)
+	defer cleanup()
+
+	nsqdURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	// The server is not trusted without its certificate authority.
+	_, err = NewFetcher(nsqdURL.Host).Fetch("stats")
+	assert.Error(t, err)
+
+	config, err := NewTLSConfig("", "", certFile, "")
+	assert.NoError(t, err)
+
+	client := NewClient(config, DefaultClientOptions)
+	assert.True(t, client.UsesTLS())
+
+	fetcher := client.NewFetcher(nsqdURL.Host)
+	assert.Equal(t, "https://"+nsqdURL.Host+"/stats", fetcher.GetURL("stats"))
+
+	body, err := fetcher.Fetch("stats")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status_code": 200}`, string(body))
+}
+
+func TestNewClient_withoutTLS(t *testing.T) {
+	assert.False(t, NewClient(nil, DefaultClientOptions).UsesTLS())
+	assert.False(t, DefaultClient.UsesTLS())
+}
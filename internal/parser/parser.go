@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 )
 
 // Parse a slice of strings into their equivalent regexp.
@@ -19,3 +22,127 @@ func Parse(filters []string) ([]*regexp.Regexp, error) {
 
 	return patterns, nil
 }
+
+// ParseNames parses a slice of strings into regexps matching whole names only,
+// e.g. "orders" matches the "orders" topic but not "orders_archive".
+func ParseNames(filters []string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+
+	for _, filter := range filters {
+		if _, err := regexp.Compile(filter); err != nil {
+			return nil, err
+		}
+
+		patterns = append(patterns, regexp.MustCompile("^(?:"+filter+")$"))
+	}
+
+	return patterns, nil
+}
+
+// MetricFilter matches metrics by name and, optionally, by tags.
+type MetricFilter struct {
+	Name *regexp.Regexp
+	Tags []*regexp.Regexp
+}
+
+// Match returns whether the name matches the filter and every tag pattern of
+// the filter matches at least one of the tags.
+func (f MetricFilter) Match(name string, tags []string) bool {
+	if !f.Name.MatchString(name) {
+		return false
+	}
+
+	for _, pattern := range f.Tags {
+		matched := false
+
+		for _, tag := range tags {
+			if pattern.MatchString(tag) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseMetricFilters parses a slice of strings into metric filters. Each string
+// is a regexp matched against metric names, optionally followed by space
+// separated tag patterns in which "*" matches any characters (e.g.
+// "channel\..* topic:orders*").
+func ParseMetricFilters(filters []string) ([]MetricFilter, error) {
+	var metricFilters []MetricFilter
+
+	for _, filter := range filters {
+		fields := strings.Fields(filter)
+		if len(fields) == 0 {
+			return nil, errors.New("empty pattern")
+		}
+
+		name, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		metricFilter := MetricFilter{Name: name}
+
+		for _, tag := range fields[1:] {
+			metricFilter.Tags = append(metricFilter.Tags, glob(tag))
+		}
+
+		metricFilters = append(metricFilters, metricFilter)
+	}
+
+	return metricFilters, nil
+}
+
+// TagFilter matches the values of a tag.
+type TagFilter struct {
+	Tag     string
+	Pattern *regexp.Regexp
+}
+
+// Match returns whether the tag and its value match the filter.
+func (f TagFilter) Match(tag string, value string) bool {
+	return f.Tag == tag && f.Pattern.MatchString(value)
+}
+
+// ParseTagFilters parses a slice of "<tag>:<pattern>" strings into tag filters.
+// The pattern is a glob in which "*" matches any characters, matching the whole
+// value (e.g. "channel:*#ephemeral"), or a regexp when enclosed in slashes
+// (e.g. "topic:/^test_/").
+func ParseTagFilters(filters []string) ([]TagFilter, error) {
+	var tagFilters []TagFilter
+
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%q is not in the <tag>:<pattern> format", filter)
+		}
+
+		tagFilter := TagFilter{Tag: parts[0], Pattern: glob(parts[1])}
+
+		if pattern := parts[1]; len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			regexp, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, err
+			}
+
+			tagFilter.Pattern = regexp
+		}
+
+		tagFilters = append(tagFilters, tagFilter)
+	}
+
+	return tagFilters, nil
+}
+
+// glob returns a regexp matching a whole string against a pattern in which "*"
+// matches any characters.
+func glob(pattern string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$")
+}
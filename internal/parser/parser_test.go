@@ -21,3 +21,76 @@ func TestParser_Parse(t *testing.T) {
 	assert.NotNil(t, patterns)
 	assert.Nil(t, err)
 }
+
+func TestParser_ParseNames(t *testing.T) {
+	patterns, err := ParseNames([]string{"orders", "events_.*"})
+
+	assert.Nil(t, err)
+	assert.Len(t, patterns, 2)
+	assert.True(t, patterns[0].MatchString("orders"))
+	assert.False(t, patterns[0].MatchString("orders_archive"))
+	assert.True(t, patterns[1].MatchString("events_v1"))
+	assert.False(t, patterns[1].MatchString("old_events_v1"))
+}
+
+func TestParser_ParseNames_Invalid(t *testing.T) {
+	patterns, err := ParseNames([]string{"*"})
+
+	assert.Nil(t, patterns)
+	assert.Equal(t, err, &syntax.Error{Code: syntax.ErrMissingRepeatArgument, Expr: "*"})
+}
+
+func TestParser_ParseMetricFilters(t *testing.T) {
+	filters, err := ParseMetricFilters([]string{`channel\.depth`, `^channel\. topic:orders* paused`})
+
+	assert.Nil(t, err)
+	assert.Len(t, filters, 2)
+
+	assert.True(t, filters[0].Match("channel.depth", nil))
+	assert.False(t, filters[0].Match("topic.depth", nil))
+
+	assert.True(t, filters[1].Match("channel.depth", []string{"node:foo", "topic:orders_v1", "paused"}))
+	assert.False(t, filters[1].Match("channel.depth", []string{"node:foo", "topic:orders_v1"}))
+	assert.False(t, filters[1].Match("channel.depth", []string{"topic:events", "paused"}))
+	assert.False(t, filters[1].Match("topic.depth", []string{"topic:orders", "paused"}))
+}
+
+func TestParser_ParseMetricFilters_Invalid(t *testing.T) {
+	filters, err := ParseMetricFilters([]string{"*depth topic:orders"})
+
+	assert.Nil(t, filters)
+	assert.Equal(t, err, &syntax.Error{Code: syntax.ErrMissingRepeatArgument, Expr: "*"})
+
+	_, err = ParseMetricFilters([]string{" "})
+	assert.EqualError(t, err, "empty pattern")
+}
+
+func TestParser_ParseTagFilters(t *testing.T) {
+	filters, err := ParseTagFilters([]string{"channel:*#ephemeral", "topic:/^test_/", "node:nsqd-1"})
+
+	assert.Nil(t, err)
+	assert.Len(t, filters, 3)
+
+	assert.True(t, filters[0].Match("channel", "billing#ephemeral"))
+	assert.False(t, filters[0].Match("channel", "billing"))
+	assert.False(t, filters[0].Match("topic", "billing#ephemeral"))
+
+	assert.True(t, filters[1].Match("topic", "test_orders"))
+	assert.False(t, filters[1].Match("topic", "orders_test_"))
+
+	assert.True(t, filters[2].Match("node", "nsqd-1"))
+	assert.False(t, filters[2].Match("node", "nsqd-10"))
+}
+
+func TestParser_ParseTagFilters_Invalid(t *testing.T) {
+	filters, err := ParseTagFilters([]string{"topic:/*/"})
+
+	assert.Nil(t, filters)
+	assert.Equal(t, err, &syntax.Error{Code: syntax.ErrMissingRepeatArgument, Expr: "*"})
+
+	_, err = ParseTagFilters([]string{"topic"})
+	assert.EqualError(t, err, `"topic" is not in the <tag>:<pattern> format`)
+
+	_, err = ParseTagFilters([]string{"topic:"})
+	assert.EqualError(t, err, `"topic:" is not in the <tag>:<pattern> format`)
+}
@@ -0,0 +1,728 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/dogstatsd"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/backoff"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/config"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/ruimarinho/nsq-dogstatsd/prometheus"
+	"github.com/ruimarinho/nsq-dogstatsd/resolver"
+	"github.com/ruimarinho/nsq-dogstatsd/sink"
+	"github.com/ruimarinho/nsq-dogstatsd/telemetry"
+	log "github.com/sirupsen/logrus"
+)
+
+// options holds the settings used to collect and publish metrics.
+type options struct {
+	nsqdHTTPAddresses    []string
+	lookupdHTTPAddresses []string
+	dogstatsdAddress     string
+	dogstatsdOptions     dogstatsd.ClientOptions
+	namespace            string
+	tags                 []string
+	excludeMetrics       []*regexp.Regexp
+	includeMetrics       []parser.MetricFilter
+	statsFilter          producer.StatsFilter
+	tagFilter            collector.TagFilter
+	clientMetrics        string
+	clientTags           []string
+	maxClientTagSets     int
+	latencyUnit          string
+	latencyDistribution  bool
+	nsqdVersionTag       bool
+	clusterRollups       bool
+	rollupTags           []string
+	interval             time.Duration
+	resolveInterval      time.Duration
+	errorPolicy          string
+	maxBackoff           time.Duration
+	sinks                []string
+	prometheusCollection string
+	telemetry            bool
+	client               *fetcher.Client
+}
+
+// newOptions returns the options used to collect metrics from a cluster.
+func newOptions(cfg config.Config, cluster config.Cluster) (options, error) {
+	excludeMetrics, err := parser.Parse(cfg.ExcludeMetrics)
+	if err != nil {
+		return options{}, err
+	}
+
+	includeMetrics, err := parser.ParseMetricFilters(cfg.IncludeMetrics)
+	if err != nil {
+		return options{}, err
+	}
+
+	statsFilter, err := producer.NewStatsFilter(cfg.IncludeTopics, cfg.IncludeChannels, cfg.IncludeClients)
+	if err != nil {
+		return options{}, err
+	}
+
+	tagFilter, err := collector.NewTagFilter(cfg.IncludeTags, cfg.ExcludeTags)
+	if err != nil {
+		return options{}, err
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.UsesTLS() {
+		if tlsConfig, err = fetcher.NewTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSRootCAFile, cfg.TLSMinVersion); err != nil {
+			return options{}, fmt.Errorf("--tls - %s", err)
+		}
+	}
+
+	return options{
+		nsqdHTTPAddresses:    cluster.NSQDHTTPAddresses,
+		lookupdHTTPAddresses: cluster.LookupdHTTPAddresses,
+		dogstatsdAddress:     cfg.DogStatsDAddress,
+		dogstatsdOptions:     cfg.GetDogStatsDOptions(),
+		namespace:            cluster.Namespace,
+		tags:                 cluster.Tags,
+		excludeMetrics:       excludeMetrics,
+		includeMetrics:       includeMetrics,
+		statsFilter:          statsFilter,
+		tagFilter:            tagFilter,
+		clientMetrics:        cfg.ClientMetrics,
+		clientTags:           cfg.ClientTags,
+		maxClientTagSets:     cfg.MaxClientTagSets,
+		latencyUnit:          cfg.LatencyUnit,
+		latencyDistribution:  cfg.LatencyDistribution,
+		nsqdVersionTag:       cfg.NSQDVersionTag,
+		clusterRollups:       cfg.ClusterRollups,
+		rollupTags:           rollupTags(cluster),
+		interval:             cfg.Interval,
+		resolveInterval:      cfg.ResolveInterval,
+		errorPolicy:          cfg.ErrorPolicy,
+		maxBackoff:           cfg.MaxBackoff,
+		sinks:                cfg.GetSinks(),
+		prometheusCollection: cfg.PrometheusCollection,
+		telemetry:            cfg.Telemetry,
+		client:               fetcher.NewClient(tlsConfig, cfg.GetClientOptions()),
+	}, nil
+}
+
+// rollupTags returns the tags added to the cluster-wide metrics of a cluster,
+// which are already tagged with the name of named clusters.
+func rollupTags(cluster config.Cluster) []string {
+	for _, tag := range cluster.Tags {
+		if strings.HasPrefix(tag, "cluster:") {
+			return nil
+		}
+	}
+
+	return []string{"cluster:default"}
+}
+
+// collectMetrics collects the metrics of every node. It returns the number of
+// nodes which failed, unless the error policy is to fail fast, in which case it
+// returns the first error instead.
+func collectMetrics(producers []producer.Producer, opts options, counters *collector.Counters, backoff *backoff.Backoff, recorder *telemetry.Recorder) ([]collector.Metric, int, error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var collectErr error
+
+	metrics := []collector.Metric{}
+	failed := 0
+	started := time.Now()
+
+	addresses := make([]string, len(producers))
+	for i, p := range producers {
+		addresses[i] = p.HTTPAddress()
+	}
+
+	backoff.Retain(addresses)
+
+	for _, p := range producers {
+		if !backoff.Ready(p.HTTPAddress(), started) {
+			log.WithField("address", p.HTTPAddress()).Debug("skipping node due to backoff")
+
+			c := collector.NewCollector(p, opts.excludeMetrics)
+			c.IncludedMetrics = opts.includeMetrics
+			c.TagFilter = opts.tagFilter
+			c.VersionTag = opts.nsqdVersionTag
+
+			mutex.Lock()
+			metrics = append(metrics, c.SkippedMetrics()...)
+			mutex.Unlock()
+
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(p producer.Producer) {
+			defer wg.Done()
+
+			start := time.Now()
+
+			c := collector.NewCollector(p, opts.excludeMetrics)
+			c.Counters = counters
+			c.Client = opts.client
+			c.StatsFilter = opts.statsFilter
+			c.IncludedMetrics = opts.includeMetrics
+			c.TagFilter = opts.tagFilter
+			c.ClientMetrics = opts.clientMetrics
+			c.ClientTags = opts.clientTags
+			c.LatencyUnit = opts.latencyUnit
+			c.LatencyDistribution = opts.latencyDistribution
+			c.VersionTag = opts.nsqdVersionTag
+			nodeMetrics, err := c.CollectMetrics()
+
+			recorder.Collection(p, time.Since(start), len(nodeMetrics), c.Excluded, err)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			// Metrics may be returned alongside an error (e.g. the service check
+			// reporting the node as unreachable), so they are kept regardless.
+			metrics = append(metrics, nodeMetrics...)
+
+			if err != nil {
+				if opts.errorPolicy == config.ErrorPolicyFailFast {
+					if collectErr == nil {
+						collectErr = err
+					}
+
+					return
+				}
+
+				failed++
+				failures, retryAt := backoff.Failure(p.HTTPAddress(), started)
+
+				log.WithFields(log.Fields{
+					"address":  p.HTTPAddress(),
+					"error":    err,
+					"failures": failures,
+					"retry_at": retryAt.Format(time.RFC3339),
+				}).Error("unable to collect metrics for node")
+
+				return
+			}
+
+			backoff.Success(p.HTTPAddress())
+		}(p)
+	}
+
+	wg.Wait()
+
+	metrics, dropped := collector.CapClientTagSets(metrics, opts.maxClientTagSets)
+	if dropped > 0 {
+		log.WithFields(log.Fields{
+			"dropped": dropped,
+			"limit":   opts.maxClientTagSets,
+		}).Warn("dropped client metrics exceeding the maximum number of unique tag sets")
+	}
+
+	if opts.clusterRollups {
+		// Rollups are filtered by their own name, as the metrics they are
+		// computed from already were.
+		c := collector.NewCollector(producer.Producer{}, opts.excludeMetrics)
+		c.IncludedMetrics = opts.includeMetrics
+		metrics = append(metrics, c.Filter(collector.Rollup(metrics, opts.rollupTags))...)
+	}
+
+	if counters != nil {
+		// Forget counters which have not been seen for a while, such as those of
+		// disconnected clients or deleted channels.
+		counters.Prune(time.Now().Add(-counterTTL(opts.interval)))
+	}
+
+	return metrics, failed, collectErr
+}
+
+func sendMetrics(producers []producer.Producer, s sink.Sink, opts options, counters *collector.Counters, backoff *backoff.Backoff, recorder *telemetry.Recorder) (int, error) {
+	metrics, failed, err := collectMetrics(producers, opts, counters, backoff, recorder)
+
+	publishErr := s.Send(metrics)
+	if publishErr == nil {
+		publishErr = s.Flush()
+	}
+
+	if reporter, ok := s.(sink.Reporter); ok {
+		recorder.Sent(reporter.Stats())
+	}
+
+	if publishErr != nil {
+		if opts.errorPolicy == config.ErrorPolicyFailFast {
+			return failed, publishErr
+		}
+
+		log.WithField("error", publishErr).Error("unable to send metrics")
+	}
+
+	return failed, err
+}
+
+func counterTTL(interval time.Duration) time.Duration {
+	if ttl := 3 * interval; ttl > 5*time.Minute {
+		return ttl
+	}
+
+	return 5 * time.Minute
+}
+
+func refreshNodes(current []producer.Producer, client *fetcher.Client, nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) []producer.Producer {
+	resolved, err := resolver.ResolveNodes(client, nsqdHTTPAddresses, lookupdHTTPAddresses)
+	if err != nil {
+		log.WithField("error", err).Warn("unable to refresh nodes, keeping previously resolved nodes")
+		return current
+	}
+
+	added, removed := resolver.DiffNodes(client, current, resolved)
+
+	for _, p := range added {
+		log.WithField("address", p.HTTPAddress()).Info("node added")
+	}
+
+	for _, p := range removed {
+		log.WithField("address", p.HTTPAddress()).Info("node removed")
+	}
+
+	return resolved
+}
+
+// sendTelemetry sends the metrics recorded about nsq_to_dogstatsd itself, if a
+// sink is given for them.
+func sendTelemetry(s sink.Sink, recorder *telemetry.Recorder) {
+	metrics := recorder.Metrics()
+	if s == nil {
+		return
+	}
+
+	err := s.Send(metrics)
+	if err == nil {
+		err = s.Flush()
+	}
+
+	if err != nil {
+		log.WithField("error", err).Error("unable to send telemetry metrics")
+	}
+}
+
+// newSink returns a sink fanning out metrics to every configured sink under
+// the given namespace. If one of them is the Prometheus exporter, it is also
+// returned so that it can be served over HTTP. Metrics are sent to DogStatsD
+// through the client of the given sink, if any, instead of a new one.
+func newSink(opts options, namespace string, shared *dogstatsd.Sink) (sink.Sink, *prometheus.Exporter, error) {
+	var exporter *prometheus.Exporter
+
+	multi := sink.NewMultiSink()
+
+	for _, name := range opts.sinks {
+		var s sink.Sink
+		var err error
+
+		switch {
+		case name == config.SinkDogStatsD && shared != nil:
+			s = shared.WithNamespace(namespace)
+		case name == config.SinkDogStatsD:
+			s, err = dogstatsd.NewDogStatsDSink(opts.dogstatsdAddress, namespace, opts.tags, opts.dogstatsdOptions)
+		case name == config.SinkPrometheus:
+			exporter = prometheus.NewExporter(namespace, opts.tags)
+			s = exporter
+		case name == config.SinkStdout:
+			s = sink.NewJSONSink(os.Stdout, namespace, opts.tags)
+		case strings.HasPrefix(name, config.SinkFilePrefix):
+			s, err = sink.NewFileSink(strings.TrimPrefix(name, config.SinkFilePrefix), namespace, opts.tags)
+		default:
+			err = fmt.Errorf("unknown sink %q", name)
+		}
+
+		if err != nil {
+			// Release the sinks configured so far.
+			multi.Close()
+
+			return nil, nil, err
+		}
+
+		multi.Sinks = append(multi.Sinks, s)
+
+		log.WithField("sink", name).Debug("configured sink")
+	}
+
+	return multi, exporter, nil
+}
+
+// dogstatsdSink returns the DogStatsD sink among the sinks of a sink returned
+// by newSink, if any.
+func dogstatsdSink(multi sink.Sink) *dogstatsd.Sink {
+	for _, s := range multi.(*sink.MultiSink).Sinks {
+		if d, ok := s.(*dogstatsd.Sink); ok {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// reload holds the settings and sinks of a cluster after the configuration has
+// been reloaded.
+type reload struct {
+	opts              options
+	sink              sink.Sink
+	exporter          *prometheus.Exporter
+	telemetry         sink.Sink
+	telemetryExporter *prometheus.Exporter
+}
+
+// newReload returns the settings and sinks of a cluster.
+func newReload(opts options) (reload, error) {
+	r := reload{opts: opts}
+
+	var err error
+	if r.sink, r.exporter, err = newSink(opts, opts.namespace, nil); err != nil {
+		return r, err
+	}
+
+	if opts.telemetry {
+		// Telemetry is sent through the DogStatsD client of the cluster, so that
+		// the bytes and packets it reports include its own.
+		if r.telemetry, r.telemetryExporter, err = newSink(opts, telemetry.Namespace, dogstatsdSink(r.sink)); err != nil {
+			r.sink.Close()
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// close closes the sinks.
+func (r reload) close() {
+	closeSink(r.sink)
+	closeSink(r.telemetry)
+
+	// Every reload creates its own client, whose idle connections would
+	// otherwise be kept open.
+	if r.opts.client != nil {
+		r.opts.client.HTTPClient.CloseIdleConnections()
+	}
+}
+
+func closeSink(s sink.Sink) {
+	if s == nil {
+		return
+	}
+
+	if err := s.Close(); err != nil {
+		log.WithField("error", err).Warn("unable to close sink")
+	}
+}
+
+// metricsLoop collects the metrics of a cluster and sends them to its sink,
+// either once, on every interval or on every Prometheus scrape. Its settings
+// can be replaced while it runs.
+type metricsLoop struct {
+	sync.Mutex
+	reload
+	producers   []producer.Producer
+	counters    *collector.Counters
+	backoff     *backoff.Backoff
+	recorder    *telemetry.Recorder
+	pending     *reload
+	done        bool
+	stopped     bool
+	reloadChan  chan bool
+	stopChan    chan bool
+	stoppedChan chan bool
+	errChan     chan error
+}
+
+// defaultBackoff is the delay before first retrying a failing node when metrics
+// are collected on every scrape without an interval.
+const defaultBackoff = 10 * time.Second
+
+// newBackoff returns the backoff of failing nodes, which are retried after the
+// interval at first.
+func newBackoff(opts options) *backoff.Backoff {
+	if opts.interval > 0 {
+		return backoff.New(opts.interval, opts.maxBackoff)
+	}
+
+	return backoff.New(defaultBackoff, opts.maxBackoff)
+}
+
+func newMetricsLoop(r reload, errChan chan error) *metricsLoop {
+	return &metricsLoop{
+		reload:      r,
+		backoff:     newBackoff(r.opts),
+		recorder:    telemetry.NewRecorder(),
+		reloadChan:  make(chan bool, 1),
+		stopChan:    make(chan bool),
+		stoppedChan: make(chan bool),
+		errChan:     errChan,
+	}
+}
+
+// collect collects metrics from every node and sends them to the sink, followed
+// by the metrics about the collection itself. It returns the number of nodes
+// which failed.
+func (l *metricsLoop) collect() int {
+	l.Lock()
+	producers, r, counters, backoff := l.producers, l.reload, l.counters, l.backoff
+	l.Unlock()
+
+	failed, err := sendMetrics(producers, r.sink, r.opts, counters, backoff, l.recorder)
+	sendTelemetry(r.telemetry, l.recorder)
+
+	if err != nil {
+		l.fail(err)
+	}
+
+	return failed
+}
+
+// fail reports an error to the main goroutine, unless the loop is stopped and
+// the error would never be received.
+func (l *metricsLoop) fail(err error) {
+	select {
+	case l.errChan <- err:
+	case <-l.stopChan:
+	}
+}
+
+// collectOnScrape collects metrics on a Prometheus scrape.
+func (l *metricsLoop) collectOnScrape() {
+	l.collect()
+}
+
+// refresh re-resolves the nodes of the cluster.
+func (l *metricsLoop) refresh() {
+	l.Lock()
+	current, opts := l.producers, l.opts
+	l.Unlock()
+
+	refreshed := refreshNodes(current, opts.client, opts.nsqdHTTPAddresses, opts.lookupdHTTPAddresses)
+
+	l.Lock()
+	l.producers = refreshed
+	l.Unlock()
+
+	l.recorder.Nodes(refreshed)
+}
+
+// Reload schedules new settings to be applied by the loop without blocking.
+// Only the latest settings are applied if several reloads are pending.
+func (l *metricsLoop) Reload(r reload) {
+	l.Lock()
+	if l.stopped {
+		// Nothing would ever apply the settings once the loop has returned.
+		l.Unlock()
+		r.close()
+
+		return
+	}
+
+	if l.pending != nil {
+		// The previous settings were never applied, so their sinks are unused.
+		l.pending.close()
+	}
+
+	l.pending = &r
+	l.Unlock()
+
+	select {
+	case l.reloadChan <- true:
+	default:
+	}
+}
+
+// Stop stops the loop, which closes its sink.
+func (l *metricsLoop) Stop() {
+	close(l.stopChan)
+}
+
+// Wait waits until the loop has stopped running, after its sink is closed, or
+// until the timeout elapses. It returns whether the loop stopped in time.
+func (l *metricsLoop) Wait(timeout time.Duration) bool {
+	select {
+	case <-l.stoppedChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// apply replaces the settings and sink of the loop with the pending ones and
+// re-resolves the nodes, as their addresses may have changed. Counters are kept
+// so that no interval is lost.
+func (l *metricsLoop) apply() {
+	l.Lock()
+	r := l.pending
+	l.pending = nil
+
+	if r == nil {
+		l.Unlock()
+		return
+	}
+
+	previous := l.reload
+	l.reload = *r
+	l.backoff = newBackoff(r.opts)
+	l.Unlock()
+
+	if previous.exporter != nil {
+		previous.exporter.SetCollect(nil)
+	}
+
+	if r.exporter != nil && r.opts.prometheusCollection == prometheus.CollectOnScrape {
+		r.exporter.SetCollect(l.collectOnScrape)
+	}
+
+	previous.close()
+
+	l.refresh()
+
+	log.Info("reloaded configuration")
+}
+
+// collectsOnTick returns whether metrics are collected on every interval, as
+// opposed to on every Prometheus scrape.
+func (l *metricsLoop) collectsOnTick() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.exporter == nil || l.opts.prometheusCollection != prometheus.CollectOnScrape
+}
+
+func newTicker(interval time.Duration, enabled bool) (*time.Ticker, <-chan time.Time) {
+	if !enabled || interval.Seconds() <= 0 {
+		// A nil channel blocks forever.
+		return nil, nil
+	}
+
+	ticker := time.NewTicker(interval)
+
+	return ticker, ticker.C
+}
+
+func stopTicker(ticker *time.Ticker) {
+	if ticker != nil {
+		ticker.Stop()
+	}
+}
+
+// finish marks the loop as stopped and closes the sinks of any settings which
+// were never applied.
+func (l *metricsLoop) finish() {
+	l.Lock()
+	r := l.pending
+	l.pending = nil
+	l.stopped = true
+	l.Unlock()
+
+	if r != nil {
+		r.close()
+	}
+}
+
+func (l *metricsLoop) run(doneChan chan bool) {
+	defer close(l.stoppedChan)
+	defer l.finish()
+
+	producers, err := resolver.ResolveNodes(l.opts.client, l.opts.nsqdHTTPAddresses, l.opts.lookupdHTTPAddresses)
+
+	if err != nil {
+		l.fail(err)
+		return
+	}
+
+	l.producers = producers
+	l.recorder.Nodes(producers)
+
+	log.WithField("interval", l.opts.interval.String()).Info("interval set")
+
+	if l.collectsOnTick() {
+		if l.opts.interval.Seconds() == 0 {
+			// Deltas of monotonic counters can only be computed across multiple
+			// collections, so they are reported as gauges when running only once.
+			failed := l.collect()
+			l.close()
+
+			// Failing nodes are tolerated while running continuously, as they are
+			// retried, but make a single collection unsuccessful.
+			if failed > 0 {
+				log.WithFields(log.Fields{"failed": failed, "nodes": len(producers)}).Error("unable to collect metrics from every node")
+			}
+
+			l.Lock()
+			l.done = true
+			l.Unlock()
+
+			select {
+			case doneChan <- failed == 0:
+			case <-l.stopChan:
+			}
+
+			return
+		}
+
+		l.counters = collector.NewCounters()
+
+		// Trigger initial metrics collection instead of waiting for first tick,
+		// which could be far in the future.
+		l.collect()
+	} else {
+		l.counters = collector.NewCounters()
+		l.exporter.SetCollect(l.collectOnScrape)
+	}
+
+	interval, resolveInterval, onTick := l.opts.interval, l.opts.resolveInterval, l.collectsOnTick()
+	ticker, timeChan := newTicker(interval, onTick)
+	resolveTicker, resolveChan := newTicker(resolveInterval, true)
+
+	if resolveChan != nil {
+		log.WithField("resolve_interval", resolveInterval.String()).Info("resolve interval set")
+	}
+
+	for {
+		select {
+		case <-timeChan:
+			l.collect()
+		case <-resolveChan:
+			l.refresh()
+		case <-l.reloadChan:
+			l.apply()
+
+			// Tickers are only replaced when their interval changes, so that
+			// reloading does not delay the next collection.
+			if l.opts.interval != interval || l.collectsOnTick() != onTick {
+				interval, onTick = l.opts.interval, l.collectsOnTick()
+
+				stopTicker(ticker)
+				ticker, timeChan = newTicker(interval, onTick)
+			}
+
+			if l.opts.resolveInterval != resolveInterval {
+				resolveInterval = l.opts.resolveInterval
+
+				stopTicker(resolveTicker)
+				resolveTicker, resolveChan = newTicker(resolveInterval, true)
+			}
+		case <-l.stopChan:
+			stopTicker(ticker)
+			stopTicker(resolveTicker)
+
+			l.Lock()
+			defer l.Unlock()
+
+			if l.exporter != nil {
+				l.exporter.SetCollect(nil)
+			}
+
+			l.close()
+
+			return
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/backoff"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/config"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/ruimarinho/nsq-dogstatsd/prometheus"
+	"github.com/ruimarinho/nsq-dogstatsd/sink"
+	"github.com/ruimarinho/nsq-dogstatsd/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProducer(t *testing.T, handler http.HandlerFunc) (producer.Producer, func()) {
+	server := httptest.NewServer(handler)
+
+	url, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	host, strPort, err := net.SplitHostPort(url.Host)
+	assert.NoError(t, err)
+
+	port, err := strconv.Atoi(strPort)
+	assert.NoError(t, err)
+
+	return producer.Producer{BroadcastAddress: host, HTTPPort: port, Hostname: host + ":" + strPort}, server.Close
+}
+
+func healthyNode(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"status_code": 200, "data": {"health": "OK", "topics": []}}`))
+}
+
+// resolvableNode serves stats like healthyNode and reports its own address on
+// /info, so that it can be resolved as a nsqd node.
+func resolvableNode(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/info" {
+		healthyNode(w, r)
+		return
+	}
+
+	host, port, _ := net.SplitHostPort(r.Host)
+	w.Write([]byte(`{"status_code": 200, "data": {"broadcast_address": "` + host + `", "hostname": "` + r.Host + `", "http_port": ` + port + `}}`))
+}
+
+func unhealthyNode(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func TestSendMetrics(t *testing.T) {
+	healthy, closeHealthy := newProducer(t, healthyNode)
+	defer closeHealthy()
+
+	unhealthy, closeUnhealthy := newProducer(t, unhealthyNode)
+	defer closeUnhealthy()
+
+	memory := sink.NewMemorySink()
+	opts := options{excludeMetrics: []*regexp.Regexp{regexp.MustCompile("memory")}, errorPolicy: config.ErrorPolicyTolerate}
+	b := backoff.New(time.Minute, time.Hour)
+	recorder := telemetry.NewRecorder()
+
+	failed, err := sendMetrics([]producer.Producer{healthy, unhealthy}, memory, opts, nil, b, recorder)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failed)
+
+	assert.Len(t, memory.Batches, 1)
+	assert.Equal(t, 1, memory.Flushes)
+	assert.ElementsMatch(t, []collector.Metric{
+		collector.NewServiceCheckMetric("node.can_connect", collector.ServiceCheckOK, "", healthy.GetTags()),
+		collector.NewServiceCheckMetric("node.health", collector.ServiceCheckOK, "OK", healthy.GetTags()),
+		collector.NewMetric("node.info", 1, healthy.GetTags()),
+		collector.NewMetric("topic.count", 0, healthy.GetTags()),
+		collector.NewServiceCheckMetric("node.can_connect", collector.ServiceCheckCritical, unhealthy.HTTPAddress()+" - response code was 500 (after 3 attempts)", unhealthy.GetTags()),
+	}, memory.Metrics())
+
+	assert.True(t, b.Ready(healthy.HTTPAddress(), time.Now()))
+	assert.False(t, b.Ready(unhealthy.HTTPAddress(), time.Now()))
+
+	// Nodes in backoff are skipped on subsequent collections and reported as
+	// unreachable.
+	failed, err = sendMetrics([]producer.Producer{healthy, unhealthy}, memory, opts, nil, b, recorder)
+	assert.NoError(t, err)
+	assert.Zero(t, failed)
+	assert.Len(t, memory.Batches[1], 5)
+	assert.Contains(t, memory.Batches[1], collector.NewServiceCheckMetric("node.can_connect", collector.ServiceCheckCritical, "skipped due to backoff after consecutive failures", unhealthy.GetTags()))
+
+	// Nodes which left the cluster are forgotten.
+	_, err = sendMetrics([]producer.Producer{healthy}, memory, opts, nil, b, recorder)
+	assert.NoError(t, err)
+	assert.True(t, b.Ready(unhealthy.HTTPAddress(), time.Now()))
+}
+
+func TestSendMetrics_FailFast(t *testing.T) {
+	unhealthy, closeUnhealthy := newProducer(t, unhealthyNode)
+	defer closeUnhealthy()
+
+	memory := sink.NewMemorySink()
+	opts := options{errorPolicy: config.ErrorPolicyFailFast}
+
+	_, err := sendMetrics([]producer.Producer{unhealthy}, memory, opts, nil, backoff.New(0, 0), telemetry.NewRecorder())
+	assert.EqualError(t, err, unhealthy.HTTPAddress()+" - response code was 500 (after 3 attempts)")
+
+	// The service check reporting the node as unreachable is still sent.
+	assert.Len(t, memory.Metrics(), 1)
+}
+
+func TestSendMetrics_ClusterRollups(t *testing.T) {
+	node := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status_code": 200, "data": {"health": "OK", "topics": [{"topic_name": "foo", "depth": 2, "channels": []}]}}`))
+	}
+
+	first, closeFirst := newProducer(t, node)
+	defer closeFirst()
+
+	second, closeSecond := newProducer(t, node)
+	defer closeSecond()
+
+	memory := sink.NewMemorySink()
+	opts := options{
+		excludeMetrics: []*regexp.Regexp{regexp.MustCompile("^(node|memory|topic.count|topic.channels|topic.backend_depth|topic.messages|topic.paused)")},
+		errorPolicy:    config.ErrorPolicyTolerate,
+		clusterRollups: true,
+		rollupTags:     rollupTags(config.Cluster{}),
+	}
+
+	_, err := sendMetrics([]producer.Producer{first, second}, memory, opts, nil, backoff.New(0, 0), telemetry.NewRecorder())
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []collector.Metric{
+		collector.NewMetric("topic.depth", 2, append(first.GetTags(), "topic:foo")),
+		collector.NewMetric("topic.depth", 2, append(second.GetTags(), "topic:foo")),
+		collector.NewMetric("cluster.topic.depth", 4, []string{"topic:foo", "cluster:default"}),
+	}, memory.Metrics())
+}
+
+func TestSendMetrics_ClusterRollupsExcluded(t *testing.T) {
+	node := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status_code": 200, "data": {"health": "OK", "topics": [{"topic_name": "foo", "depth": 2, "channels": []}]}}`))
+	}
+
+	first, closeFirst := newProducer(t, node)
+	defer closeFirst()
+
+	memory := sink.NewMemorySink()
+	opts := options{
+		excludeMetrics: []*regexp.Regexp{regexp.MustCompile("^(node|memory|topic.count|topic.channels|topic.backend_depth|topic.messages|topic.paused)"), regexp.MustCompile(`^cluster\.`)},
+		errorPolicy:    config.ErrorPolicyTolerate,
+		clusterRollups: true,
+		rollupTags:     rollupTags(config.Cluster{}),
+	}
+
+	_, err := sendMetrics([]producer.Producer{first}, memory, opts, nil, backoff.New(0, 0), telemetry.NewRecorder())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []collector.Metric{
+		collector.NewMetric("topic.depth", 2, append(first.GetTags(), "topic:foo")),
+	}, memory.Metrics())
+}
+
+func TestRollupTags(t *testing.T) {
+	assert.Equal(t, []string{"cluster:default"}, rollupTags(config.Cluster{Tags: []string{"environment:production"}}))
+	assert.Empty(t, rollupTags(config.Cluster{Tags: []string{"cluster:east"}}))
+}
+
+func TestNewSink(t *testing.T) {
+	s, exporter, err := newSink(options{sinks: []string{config.SinkDogStatsD, config.SinkPrometheus, config.SinkStdout}, dogstatsdAddress: "127.0.0.1:8125", namespace: "nsq"}, "nsq", nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &prometheus.Exporter{}, exporter)
+	assert.Len(t, s.(*sink.MultiSink).Sinks, 3)
+
+	_, _, err = newSink(options{sinks: []string{"foo"}}, "nsq", nil)
+	assert.EqualError(t, err, `unknown sink "foo"`)
+
+	_, _, err = newSink(options{sinks: []string{"file:/nonexistent/metrics.json"}}, "nsq", nil)
+	assert.Error(t, err)
+}
+
+func TestNewReload_Telemetry(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	defer conn.Close()
+
+	r, err := newReload(options{sinks: []string{config.SinkDogStatsD}, dogstatsdAddress: conn.LocalAddr().String(), namespace: "nsq", telemetry: true})
+	assert.NoError(t, err)
+
+	// Telemetry is sent through the client of the cluster, which counts it.
+	assert.NoError(t, r.telemetry.Send([]collector.Metric{collector.NewMetric("resolver.nodes", 1, []string{})}))
+	assert.NoError(t, r.telemetry.Flush())
+
+	buffer := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "nsq_to_dogstatsd.resolver.nodes:1|g", strings.TrimSpace(string(buffer[:n])))
+
+	stats := r.sink.(sink.Reporter).Stats()
+	assert.Equal(t, uint64(1), stats.PacketsSent)
+
+	r.close()
+}
+
+func TestMetricsLoop_Reload(t *testing.T) {
+	healthy, closeHealthy := newProducer(t, resolvableNode)
+	defer closeHealthy()
+
+	errChan := make(chan error)
+	opts := options{
+		nsqdHTTPAddresses: []string{healthy.HTTPAddress()},
+		client:            fetcher.DefaultClient,
+		excludeMetrics:    []*regexp.Regexp{regexp.MustCompile("node|memory")},
+		interval:          10 * time.Millisecond,
+		errorPolicy:       config.ErrorPolicyTolerate,
+	}
+
+	first := sink.NewMemorySink()
+	loop := newMetricsLoop(reload{opts: opts, sink: first}, errChan)
+
+	go loop.run(make(chan bool))
+
+	assert.Eventually(t, func() bool { return len(first.Metrics()) > 0 }, time.Second, time.Millisecond)
+
+	second := sink.NewMemorySink()
+	opts.excludeMetrics = []*regexp.Regexp{regexp.MustCompile("node")}
+	loop.Reload(reload{opts: opts, sink: second})
+
+	assert.Eventually(t, func() bool { return len(second.Metrics()) > 0 }, time.Second, time.Millisecond)
+
+	first.Lock()
+	assert.True(t, first.Closed)
+	first.Unlock()
+
+	assert.Contains(t, second.Metrics(), collector.NewMetric("memory.heap_objects", 0, healthy.GetTags()))
+
+	loop.Stop()
+
+	assert.Eventually(t, func() bool {
+		second.Lock()
+		defer second.Unlock()
+
+		return second.Closed
+	}, time.Second, time.Millisecond)
+}
+
+func TestMetricsLoop_StopWhileFailing(t *testing.T) {
+	unhealthy, closeUnhealthy := newProducer(t, unhealthyNode)
+	defer closeUnhealthy()
+
+	// Nobody receives the error of the failing node, which must not prevent the
+	// loop from stopping.
+	opts := options{
+		nsqdHTTPAddresses: []string{unhealthy.HTTPAddress()},
+		client:            fetcher.DefaultClient,
+		interval:          time.Minute,
+		errorPolicy:       config.ErrorPolicyFailFast,
+	}
+
+	loop := newMetricsLoop(reload{opts: opts, sink: sink.NewMemorySink()}, make(chan error))
+
+	go loop.run(make(chan bool))
+
+	loop.Stop()
+	assert.True(t, loop.Wait(time.Second))
+}
+
+func TestMetricsLoop_ReloadAfterStop(t *testing.T) {
+	healthy, closeHealthy := newProducer(t, resolvableNode)
+	defer closeHealthy()
+
+	opts := options{
+		nsqdHTTPAddresses: []string{healthy.HTTPAddress()},
+		client:            fetcher.DefaultClient,
+		interval:          time.Minute,
+		errorPolicy:       config.ErrorPolicyTolerate,
+	}
+
+	loop := newMetricsLoop(reload{opts: opts, sink: sink.NewMemorySink()}, make(chan error))
+
+	go loop.run(make(chan bool))
+
+	loop.Stop()
+	assert.True(t, loop.Wait(time.Second))
+
+	// Settings which can no longer be applied are closed right away.
+	unused := sink.NewMemorySink()
+	loop.Reload(reload{opts: opts, sink: unused})
+
+	unused.Lock()
+	assert.True(t, unused.Closed)
+	unused.Unlock()
+}
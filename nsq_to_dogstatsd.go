@@ -5,101 +5,253 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"regexp"
-	"sync"
 	"syscall"
 	"time"
 
-	"github.com/DataDog/datadog-go/statsd"
 	"github.com/ruimarinho/nsq-dogstatsd/collector"
 	"github.com/ruimarinho/nsq-dogstatsd/dogstatsd"
-	"github.com/ruimarinho/nsq-dogstatsd/internal/checker"
-	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/config"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
 	"github.com/ruimarinho/nsq-dogstatsd/internal/slice"
-	"github.com/ruimarinho/nsq-dogstatsd/producer"
-	"github.com/ruimarinho/nsq-dogstatsd/resolver"
+	"github.com/ruimarinho/nsq-dogstatsd/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+// shutdownTimeout bounds how long buffered metrics are flushed for on exit.
+const shutdownTimeout = 10 * time.Second
+
 var (
 	interval                = flag.Duration("interval", time.Duration(0), `interval for collecting metrics (default "none")`)
+	resolveInterval         = flag.Duration("resolve-interval", time.Duration(0), `interval for re-resolving nsqd nodes when running continuously (default "none")`)
 	namespace               = flag.String("namespace", "nsq", "namespace for metrics")
-	dogstatsdAddress        = flag.String("dogstatsd-address", "127.0.0.1:8125", "<address>:<port> to connect to dogstatsd")
+	dogstatsdAddress        = flag.String("dogstatsd-address", "127.0.0.1:8125", "<address>:<port> or unix://<path> (also unixgram://<path> or unixstream://<path>) of the socket to connect to dogstatsd")
+	dogstatsdBufferPool     = flag.Int("dogstatsd-buffer-pool-size", dogstatsd.DefaultClientOptions.BufferPoolSize, `number of buffers of the dogstatsd client (0 for the default of the transport)`)
+	dogstatsdSenderQueue    = flag.Int("dogstatsd-sender-queue-size", dogstatsd.DefaultClientOptions.SenderQueueSize, `number of buffers queued for sending by the dogstatsd client, dropping any further buffers (0 for the default of the transport)`)
+	dogstatsdTimeout        = flag.Duration("dogstatsd-socket-timeout", dogstatsd.DefaultClientOptions.SocketTimeout, "timeout for writing to a dogstatsd unix socket, dropping metrics which can not be written in time")
+	dogstatsdMaxPacketSize  = flag.Int("dogstatsd-max-packet-size", dogstatsd.DefaultClientOptions.MaxPacketSize, `maximum size in bytes of the packets sent to dogstatsd, into which metrics are buffered (0 for 1432 over UDP or 8192 over a unix socket)`)
+	dogstatsdFlushInterval  = flag.Duration("dogstatsd-flush-interval", dogstatsd.DefaultClientOptions.FlushInterval, "interval for sending packets of buffered metrics which are not full yet")
 	showVersion             = flag.Bool("version", false, "show version information")
+	configFile              = flag.String("config", "", `path to a YAML configuration file, whose settings are overridden by flags (default "none")`)
+	errorPolicy             = flag.String("error-policy", config.ErrorPolicyTolerate, `policy for handling node errors, either "tolerate" (log and retry with backoff) or "fail-fast" (exit)`)
+	prometheusAddress       = flag.String("prometheus-address", "", `<address>:<port> to serve metrics of the prometheus sink on /metrics (default "none")`)
+	prometheusCollection    = flag.String("prometheus-collection", prometheus.CollectOnScrape, `when to collect prometheus metrics, either "scrape" (on every request) or "cache" (on every interval)`)
+	maxBackoff              = flag.Duration("max-backoff", 5*time.Minute, "maximum delay before retrying a failing nsqd node")
+	httpAddress             = flag.String("http-address", "", `<address>:<port> to serve /health, /ready and /status on (default "none")`)
+	readyIntervals          = flag.Int("ready-intervals", 3, "number of intervals within which a successful collection is required to be ready")
+	tlsCert                 = flag.String("tls-cert", "", `path to a client certificate for querying nsqd and nsqlookupd over HTTPS (default "none")`)
+	tlsKey                  = flag.String("tls-key", "", `path to the key of the client certificate (default "none")`)
+	tlsRootCAFile           = flag.String("tls-root-ca-file", "", `path to a PEM file of certificate authorities to verify nsqd and nsqlookupd with (default "system roots")`)
+	connectTimeout          = flag.Duration("connect-timeout", fetcher.DefaultClientOptions.ConnectTimeout, `timeout for connecting to nsqd and nsqlookupd (0 for "none")`)
+	requestTimeout          = flag.Duration("request-timeout", fetcher.DefaultClientOptions.RequestTimeout, `timeout for each request to nsqd and nsqlookupd, including reading the response (0 for "none")`)
+	maxRetries              = flag.Int("max-retries", fetcher.DefaultClientOptions.MaxRetries, "maximum number of retries of a failed request to nsqd and nsqlookupd")
+	retryBackoff            = flag.Duration("retry-backoff", fetcher.DefaultClientOptions.RetryBackoff, "delay before the first retry of a failed request, doubled on every retry with a random jitter")
+	tlsMinVersion           = flag.String("tls-min-version", "", `minimum TLS version, either "1.0", "1.1", "1.2" or "1.3" (default "1.2")`)
 	excludeMetricsPatterns  slice.StringSlice
+	includeMetricsPatterns  slice.StringSlice
+	includeTags             slice.StringSlice
+	excludeTags             slice.StringSlice
+	includeTopics           slice.StringSlice
+	includeChannels         slice.StringSlice
 	nsqdHTTPAddresses       slice.StringSlice
 	nsqlookupdHTTPAddresses slice.StringSlice
 	tags                    slice.StringSlice
+	sinks                   slice.StringSlice
+	includeClients          = flag.Bool("include-clients", true, "collect metrics of the clients of every channel")
+	clientMetrics           = flag.String("client-metrics", collector.ClientMetricsAll, `report the metrics of "all" clients, their "sum" or "max" per channel, their sum per channel and "hostname" or "agent", or "none" of them`)
+	maxClientTagSets        = flag.Int("max-client-tag-sets", 0, `maximum number of unique tag sets of client metrics per collection, dropping the metrics of any further client (0 for "none")`)
+	clientTags              slice.StringSlice
+	latencyUnit             = flag.String("latency-unit", collector.LatencyUnitNanoseconds, `unit of end-to-end processing latency metrics, either "ns", "ms" or "s"`)
+	latencyDistribution     = flag.Bool("latency-distribution", false, "send end-to-end processing latency metrics as distributions instead of gauges")
+	nsqdVersionTag          = flag.Bool("nsqd-version-tag", false, "tag every metric of a nsqd node with its nsqd_version, instead of only node.info")
+	clusterRollups          = flag.Bool("cluster-rollups", false, "send cluster-wide depth, in-flight, deferred, clients and end-to-end latency metrics of every topic and channel under the cluster. prefix")
 	verbose                 = flag.Int("verbose", 0, "verbosity level (0-3)")
+	selfTelemetry           = flag.Bool("telemetry", true, "send metrics about nsq_to_dogstatsd itself under the nsq_to_dogstatsd namespace")
 	version                 = "master"
 )
 
 func init() {
 	flag.Var(&excludeMetricsPatterns, "exclude-metrics", "exclude metrics using a regular expression pattern (can be specified multiple times)")
+	flag.Var(&includeMetricsPatterns, "include-metrics", `only include metrics matching a regular expression pattern, optionally followed by tag patterns (e.g. "channel\..* topic:orders*"), unless excluded (can be specified multiple times)`)
+	flag.Var(&includeTags, "include-tag", `only collect topics, channels, clients or nodes whose "topic", "channel", "client_hostname" or "node" tag matches a <tag>:<glob> or <tag>:/<regexp>/ pattern (can be specified multiple times)`)
+	flag.Var(&excludeTags, "exclude-tag", `skip topics, channels, clients or nodes whose "topic", "channel", "client_hostname" or "node" tag matches a <tag>:<glob> or <tag>:/<regexp>/ pattern (can be specified multiple times)`)
+	flag.Var(&includeTopics, "include-topic", "only collect topics whose whole name matches a regular expression pattern (can be specified multiple times)")
+	flag.Var(&includeChannels, "include-channel", "only collect channels whose whole name matches a regular expression pattern (can be specified multiple times)")
+	flag.Var(&clientTags, "client-tag", `client tag to keep on client metrics, either "client_id", "client_agent", "client_hostname" or "client_address", merging clients with the same tags (can be specified multiple times) (default all)`)
 	flag.Var(&tags, "tag", `add global tags (can be specified multiple times)`)
+	flag.Var(&sinks, "sink", `send metrics to "dogstatsd", "prometheus", "stdout" or "file:<path>" (can be specified multiple times) (default "dogstatsd")`)
 	flag.Var(&nsqdHTTPAddresses, "nsqd-http-address", "<address>:<port> of nsqd node to query stats for (can be specified multiple times)")
 	flag.Var(&nsqlookupdHTTPAddresses, "lookupd-http-address", "<address>:<port> of nsqlookupd to query nodes for (can be specified multiple times)")
 }
 
-func sendMetrics(producers []producer.Producer, client *statsd.Client, interval time.Duration, excludeMetrics []*regexp.Regexp, doneChan chan bool, errChan chan error) {
-	var wg sync.WaitGroup
-	for _, p := range producers {
-		wg.Add(1)
-
-		go func(p producer.Producer) {
-			defer wg.Done()
-
-			c := collector.NewCollector(p, excludeMetrics)
-			metrics, err := c.CollectMetrics()
-			if err != nil {
-				errChan <- err
-				return
-			}
-
-			for _, m := range metrics {
-				if err = client.Gauge(m.Name, m.Value, m.Tags, m.Rate); err != nil {
-					errChan <- err
-					return
-				}
-			}
-		}(p)
-	}
-
-	wg.Wait()
-
-	if interval.Seconds() == 0 {
-		doneChan <- true
-		return
+// flagConfig returns the configuration given by the command line flags,
+// including the default values of flags that were not set.
+func flagConfig() config.Config {
+	return config.Config{
+		Interval:                 *interval,
+		ResolveInterval:          *resolveInterval,
+		Namespace:                *namespace,
+		DogStatsDAddress:         *dogstatsdAddress,
+		DogStatsDBufferPoolSize:  *dogstatsdBufferPool,
+		DogStatsDSenderQueueSize: *dogstatsdSenderQueue,
+		DogStatsDSocketTimeout:   *dogstatsdTimeout,
+		DogStatsDMaxPacketSize:   *dogstatsdMaxPacketSize,
+		DogStatsDFlushInterval:   *dogstatsdFlushInterval,
+		ErrorPolicy:              *errorPolicy,
+		MaxBackoff:               *maxBackoff,
+		Sinks:                    sinks,
+		PrometheusAddress:        *prometheusAddress,
+		PrometheusCollection:     *prometheusCollection,
+		ExcludeMetrics:           excludeMetricsPatterns,
+		IncludeMetrics:           includeMetricsPatterns,
+		IncludeTags:              includeTags,
+		ExcludeTags:              excludeTags,
+		IncludeTopics:            includeTopics,
+		IncludeChannels:          includeChannels,
+		IncludeClients:           *includeClients,
+		ClientMetrics:            *clientMetrics,
+		ClientTags:               clientTags,
+		MaxClientTagSets:         *maxClientTagSets,
+		LatencyUnit:              *latencyUnit,
+		LatencyDistribution:      *latencyDistribution,
+		NSQDVersionTag:           *nsqdVersionTag,
+		ClusterRollups:           *clusterRollups,
+		NSQDHTTPAddresses:        nsqdHTTPAddresses,
+		LookupdHTTPAddresses:     nsqlookupdHTTPAddresses,
+		Tags:                     tags,
+		Verbose:                  *verbose,
+		Telemetry:                *selfTelemetry,
+		HTTPAddress:              *httpAddress,
+		ReadyIntervals:           *readyIntervals,
+		TLSCert:                  *tlsCert,
+		TLSKey:                   *tlsKey,
+		TLSRootCAFile:            *tlsRootCAFile,
+		TLSMinVersion:            *tlsMinVersion,
+		ConnectTimeout:           *connectTimeout,
+		RequestTimeout:           *requestTimeout,
+		MaxRetries:               *maxRetries,
+		RetryBackoff:             *retryBackoff,
 	}
 }
 
-func sendMetricsLoop(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string, dogstatsdAddress string, namespace string, tags []string, excludeMetrics []*regexp.Regexp, interval time.Duration, doneChan chan bool, errChan chan error) {
-	producers, err := resolver.ResolveNodes(nsqdHTTPAddresses, lookupdHTTPAddresses)
-
-	if err != nil {
-		errChan <- err
-		return
+// loadConfig returns the configuration given by the flags, applied on top of
+// the configuration file if there is one.
+func loadConfig() (config.Config, error) {
+	flags := flagConfig()
+	if *configFile == "" {
+		return flags, nil
 	}
 
-	client, err := dogstatsd.NewDogStatsDClient(dogstatsdAddress, namespace, tags)
+	cfg, err := config.Load(*configFile, flags)
 	if err != nil {
-		errChan <- err
-		return
+		return cfg, err
 	}
 
-	timeChan := time.NewTimer(0).C
-
-	log.WithField("interval", interval.String()).Info("interval set")
+	// Flags explicitly set on the command line take precedence over the file.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "interval":
+			cfg.Interval = flags.Interval
+		case "resolve-interval":
+			cfg.ResolveInterval = flags.ResolveInterval
+		case "namespace":
+			cfg.Namespace = flags.Namespace
+		case "dogstatsd-address":
+			cfg.DogStatsDAddress = flags.DogStatsDAddress
+		case "dogstatsd-buffer-pool-size":
+			cfg.DogStatsDBufferPoolSize = flags.DogStatsDBufferPoolSize
+		case "dogstatsd-sender-queue-size":
+			cfg.DogStatsDSenderQueueSize = flags.DogStatsDSenderQueueSize
+		case "dogstatsd-socket-timeout":
+			cfg.DogStatsDSocketTimeout = flags.DogStatsDSocketTimeout
+		case "dogstatsd-max-packet-size":
+			cfg.DogStatsDMaxPacketSize = flags.DogStatsDMaxPacketSize
+		case "dogstatsd-flush-interval":
+			cfg.DogStatsDFlushInterval = flags.DogStatsDFlushInterval
+		case "error-policy":
+			cfg.ErrorPolicy = flags.ErrorPolicy
+		case "max-backoff":
+			cfg.MaxBackoff = flags.MaxBackoff
+		case "sink":
+			cfg.Sinks = flags.Sinks
+		case "prometheus-address":
+			cfg.PrometheusAddress = flags.PrometheusAddress
+		case "prometheus-collection":
+			cfg.PrometheusCollection = flags.PrometheusCollection
+		case "exclude-metrics":
+			cfg.ExcludeMetrics = flags.ExcludeMetrics
+		case "include-metrics":
+			cfg.IncludeMetrics = flags.IncludeMetrics
+		case "include-tag":
+			cfg.IncludeTags = flags.IncludeTags
+		case "exclude-tag":
+			cfg.ExcludeTags = flags.ExcludeTags
+		case "client-metrics":
+			cfg.ClientMetrics = flags.ClientMetrics
+		case "client-tag":
+			cfg.ClientTags = flags.ClientTags
+		case "max-client-tag-sets":
+			cfg.MaxClientTagSets = flags.MaxClientTagSets
+		case "latency-unit":
+			cfg.LatencyUnit = flags.LatencyUnit
+		case "latency-distribution":
+			cfg.LatencyDistribution = flags.LatencyDistribution
+		case "nsqd-version-tag":
+			cfg.NSQDVersionTag = flags.NSQDVersionTag
+		case "cluster-rollups":
+			cfg.ClusterRollups = flags.ClusterRollups
+		case "include-topic":
+			cfg.IncludeTopics = flags.IncludeTopics
+		case "include-channel":
+			cfg.IncludeChannels = flags.IncludeChannels
+		case "include-clients":
+			cfg.IncludeClients = flags.IncludeClients
+		case "nsqd-http-address":
+			cfg.NSQDHTTPAddresses = flags.NSQDHTTPAddresses
+		case "lookupd-http-address":
+			cfg.LookupdHTTPAddresses = flags.LookupdHTTPAddresses
+		case "tag":
+			cfg.Tags = flags.Tags
+		case "verbose":
+			cfg.Verbose = flags.Verbose
+		case "telemetry":
+			cfg.Telemetry = flags.Telemetry
+		case "http-address":
+			cfg.HTTPAddress = flags.HTTPAddress
+		case "ready-intervals":
+			cfg.ReadyIntervals = flags.ReadyIntervals
+		case "tls-cert":
+			cfg.TLSCert = flags.TLSCert
+		case "tls-key":
+			cfg.TLSKey = flags.TLSKey
+		case "tls-root-ca-file":
+			cfg.TLSRootCAFile = flags.TLSRootCAFile
+		case "tls-min-version":
+			cfg.TLSMinVersion = flags.TLSMinVersion
+		case "connect-timeout":
+			cfg.ConnectTimeout = flags.ConnectTimeout
+		case "request-timeout":
+			cfg.RequestTimeout = flags.RequestTimeout
+		case "max-retries":
+			cfg.MaxRetries = flags.MaxRetries
+		case "retry-backoff":
+			cfg.RetryBackoff = flags.RetryBackoff
+		}
+	})
 
-	if interval.Seconds() > 0 {
-		// Trigger initial metrics collection instead of waiting for first tick,
-		// which could be far in the future.
-		sendMetrics(producers, client, interval, excludeMetrics, doneChan, errChan)
-		timeChan = time.NewTicker(interval).C
-	}
+	return cfg, nil
+}
 
-	for range timeChan {
-		sendMetrics(producers, client, interval, excludeMetrics, doneChan, errChan)
+// setLogLevel sets the log level matching the verbosity level.
+func setLogLevel(verbose int) {
+	switch verbose {
+	case 0:
+		log.SetLevel(log.ErrorLevel)
+	case 1:
+		log.SetLevel(log.WarnLevel)
+	case 2:
+		log.SetLevel(log.InfoLevel)
+	case 3:
+		log.SetLevel(log.DebugLevel)
 	}
 }
 
@@ -117,34 +269,9 @@ func main() {
 		os.Exit(0)
 	}
 
-	if len(nsqdHTTPAddresses) == 0 && len(nsqlookupdHTTPAddresses) == 0 {
-		log.Fatal("--lookup-http-address or --nsqd-http-address must be provided at least once")
-	}
-
-	if err := checker.CheckAddresses(nsqdHTTPAddresses); err != nil {
-		log.Fatalf("--nsqd-http-address - %s", err)
-	}
-
-	if err := checker.CheckAddresses(nsqlookupdHTTPAddresses); err != nil {
-		log.Fatalf("--nsqlookupd-http-address - %s", err)
-	}
-
-	excludedMetrics, err := parser.Parse(excludeMetricsPatterns)
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("--filter-metrics contains invalid regexp - %s", err)
-	}
-
-	switch *verbose {
-	case 0:
-		log.SetLevel(log.ErrorLevel)
-	case 1:
-		log.SetLevel(log.WarnLevel)
-	case 2:
-		log.SetLevel(log.InfoLevel)
-	case 3:
-		log.SetLevel(log.DebugLevel)
-	default:
-		log.Fatalf("--verbose is outside valid range (0-3)")
+		log.Fatalf("--config - %s", err)
 	}
 
 	doneChan := make(chan bool)
@@ -152,16 +279,50 @@ func main() {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	go sendMetricsLoop(nsqdHTTPAddresses, nsqlookupdHTTPAddresses, *dogstatsdAddress, *namespace, tags, excludedMetrics, *interval, doneChan, errChan)
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
 
-	select {
-	case <-doneChan:
-		log.Info("exiting")
-		os.Exit(0)
-	case err := <-errChan:
-		log.WithField("error", err).Fatal("exiting due to error")
-	case signal := <-signalChan:
-		log.WithField("signal", signal).Info("exiting due to signal")
-		os.Exit(0)
+	supervisor := newSupervisor(doneChan, errChan)
+	if err := supervisor.Apply(cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	succeeded := true
+
+	for {
+		select {
+		case ok := <-doneChan:
+			succeeded = succeeded && ok
+
+			// Clusters may have been added or removed by reloads, so the loops
+			// left are counted anew.
+			if supervisor.Pending() == 0 {
+				if !succeeded {
+					log.Fatal("exiting after failing to collect metrics from some nodes")
+				}
+
+				log.Info("exiting")
+				os.Exit(0)
+			}
+		case err := <-errChan:
+			log.WithField("error", err).Fatal("exiting due to error")
+		case <-reloadChan:
+			log.Info("reloading configuration")
+
+			cfg, err := loadConfig()
+			if err == nil {
+				err = supervisor.Apply(cfg)
+			}
+
+			if err != nil {
+				log.WithField("error", err).Error("invalid configuration, keeping the current one")
+			}
+		case signal := <-signalChan:
+			log.WithField("signal", signal).Info("exiting due to signal")
+
+			// Flush any buffered metrics before exiting.
+			supervisor.Stop(shutdownTimeout)
+			os.Exit(0)
+		}
 	}
 }
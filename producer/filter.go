@@ -0,0 +1,177 @@
+package producer
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/parser"
+)
+
+// StatsFilter selects the topics, channels and clients retrieved from a nsqd.
+// Filters are pushed down into the stats query when nsqd supports them, that is
+// when a single literal topic or channel name is given, and are applied to the
+// response otherwise. The zero value selects everything.
+type StatsFilter struct {
+	topics         []*regexp.Regexp
+	channels       []*regexp.Regexp
+	topic          string
+	channel        string
+	excludeClients bool
+}
+
+// NewStatsFilter returns a StatsFilter selecting the topics and channels whose
+// whole name matches any of the given regular expressions, if any.
+func NewStatsFilter(topics []string, channels []string, includeClients bool) (StatsFilter, error) {
+	filter := StatsFilter{excludeClients: !includeClients}
+
+	var err error
+	if filter.topics, err = parser.ParseNames(topics); err != nil {
+		return filter, err
+	}
+
+	if filter.channels, err = parser.ParseNames(channels); err != nil {
+		return filter, err
+	}
+
+	filter.topic = literal(topics)
+	filter.channel = literal(channels)
+
+	return filter, nil
+}
+
+// literal returns the name matched by a single regular expression which only
+// matches a literal name (e.g. "orders" or "orders\.v1"), or an empty string.
+func literal(patterns []string) string {
+	if len(patterns) != 1 {
+		return ""
+	}
+
+	prefix, complete := regexp.MustCompile(patterns[0]).LiteralPrefix()
+	if !complete {
+		return ""
+	}
+
+	return prefix
+}
+
+// Path returns the path of the stats query, including the filters supported
+// by nsqd.
+func (f StatsFilter) Path() string {
+	query := url.Values{}
+
+	if f.topic != "" {
+		query.Set("topic", f.topic)
+	}
+
+	if f.channel != "" {
+		query.Set("channel", f.channel)
+	}
+
+	if f.excludeClients {
+		query.Set("include_clients", "false")
+	}
+
+	if len(query) == 0 {
+		return "stats?format=json"
+	}
+
+	return "stats?format=json&" + query.Encode()
+}
+
+// Apply removes the topics and channels not selected by the filter. Like nsqd,
+// topics left without any channel are removed when filtering channels, and the
+// publish counts of producers are only kept for the selected topics.
+func (f StatsFilter) Apply(data *StatsData) {
+	if len(f.topics) == 0 && len(f.channels) == 0 && !f.excludeClients {
+		return
+	}
+
+	f.applyProducers(data)
+
+	topics := []TopicStats{}
+
+	for _, topic := range data.Topics {
+		if !matches(f.topics, topic.TopicName) {
+			continue
+		}
+
+		channels := []nsqd.ChannelStats{}
+
+		for _, channel := range topic.Channels {
+			if !matches(f.channels, channel.ChannelName) {
+				continue
+			}
+
+			if f.excludeClients {
+				if channel.ClientCount < len(channel.Clients) {
+					channel.ClientCount = len(channel.Clients)
+				}
+
+				channel.Clients = nil
+			}
+
+			channels = append(channels, channel)
+		}
+
+		if len(f.channels) > 0 && len(channels) == 0 {
+			continue
+		}
+
+		topic.Channels = channels
+		topics = append(topics, topic)
+	}
+
+	data.Topics = topics
+}
+
+// applyProducers removes the producers, unless clients are included, and the
+// publish counts of topics not selected by the filter. Producers left without
+// any publish count are removed when filtering topics.
+func (f StatsFilter) applyProducers(data *StatsData) {
+	if f.excludeClients {
+		data.Producers = nil
+		return
+	}
+
+	if len(f.topics) == 0 {
+		return
+	}
+
+	producers := []nsqd.ClientStats{}
+
+	for _, producer := range data.Producers {
+		pubCounts := []nsqd.PubCount{}
+
+		for _, pubCount := range producer.PubCounts {
+			if matches(f.topics, pubCount.Topic) {
+				pubCounts = append(pubCounts, pubCount)
+			}
+		}
+
+		if len(pubCounts) == 0 {
+			continue
+		}
+
+		producer.PubCounts = pubCounts
+		producers = append(producers, producer)
+	}
+
+	data.Producers = producers
+}
+
+// matches returns whether the name matches any of the patterns, or whether
+// there are no patterns at all.
+func matches(patterns []*regexp.Regexp, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
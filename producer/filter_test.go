@@ -0,0 +1,141 @@
+package producer_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	. "github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatsFilter_Invalid(t *testing.T) {
+	_, err := NewStatsFilter([]string{"*"}, nil, true)
+	assert.Error(t, err)
+
+	_, err = NewStatsFilter(nil, []string{"*"}, true)
+	assert.Error(t, err)
+}
+
+func TestStatsFilter_Path(t *testing.T) {
+	var tests = []struct {
+		topics         []string
+		channels       []string
+		includeClients bool
+		expected       string
+	}{
+		{nil, nil, true, "stats?format=json"},
+		{nil, nil, false, "stats?format=json&include_clients=false"},
+		{[]string{"orders"}, nil, true, "stats?format=json&topic=orders"},
+		{[]string{`orders\.v1`}, []string{"billing"}, false, "stats?format=json&channel=billing&include_clients=false&topic=orders.v1"},
+		{[]string{"orders.*"}, []string{"billing"}, true, "stats?format=json&channel=billing"},
+		{[]string{"orders", "events"}, nil, true, "stats?format=json"},
+		{[]string{"orders#ephemeral"}, nil, true, "stats?format=json&topic=orders%23ephemeral"},
+	}
+
+	for _, tt := range tests {
+		filter, err := NewStatsFilter(tt.topics, tt.channels, tt.includeClients)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, filter.Path())
+	}
+
+	assert.Equal(t, "stats?format=json", StatsFilter{}.Path())
+}
+
+func newStatsData() StatsData {
+	clients := []nsqd.ClientStats{{ClientID: "foo"}, {ClientID: "bar"}}
+
+	return StatsData{Topics: []TopicStats{
+		{TopicStats: nsqd.TopicStats{TopicName: "orders", Channels: []nsqd.ChannelStats{{ChannelName: "billing", Clients: clients}, {ChannelName: "shipping", Clients: clients}}}},
+		{TopicStats: nsqd.TopicStats{TopicName: "orders_archive", Channels: []nsqd.ChannelStats{{ChannelName: "archiver", Clients: clients}}}},
+		{TopicStats: nsqd.TopicStats{TopicName: "events", Channels: []nsqd.ChannelStats{{ChannelName: "billing", Clients: clients}}}},
+	}, Producers: []nsqd.ClientStats{
+		{ClientID: "api", PubCounts: []nsqd.PubCount{{Topic: "orders", Count: 3}, {Topic: "events", Count: 1}}},
+		{ClientID: "tracker", PubCounts: []nsqd.PubCount{{Topic: "events", Count: 2}}},
+	}}
+}
+
+func TestStatsFilter_Apply(t *testing.T) {
+	data := newStatsData()
+	StatsFilter{}.Apply(&data)
+	assert.Equal(t, newStatsData(), data)
+
+	filter, err := NewStatsFilter([]string{"orders"}, nil, true)
+	assert.NoError(t, err)
+
+	data = newStatsData()
+	filter.Apply(&data)
+	assert.Len(t, data.Topics, 1)
+	assert.Equal(t, "orders", data.Topics[0].TopicName)
+	assert.Len(t, data.Topics[0].Channels, 2)
+
+	// Only the publish counts of the selected topics are kept.
+	assert.Equal(t, []nsqd.ClientStats{{ClientID: "api", PubCounts: []nsqd.PubCount{{Topic: "orders", Count: 3}}}}, data.Producers)
+
+	// Topics without any matching channel are removed.
+	filter, err = NewStatsFilter(nil, []string{"bill.*"}, true)
+	assert.NoError(t, err)
+
+	data = newStatsData()
+	filter.Apply(&data)
+	assert.Len(t, data.Topics, 2)
+	assert.Equal(t, "orders", data.Topics[0].TopicName)
+	assert.Equal(t, []string{"billing"}, []string{data.Topics[0].Channels[0].ChannelName})
+	assert.Equal(t, "events", data.Topics[1].TopicName)
+
+	// Clients are removed but still counted.
+	filter, err = NewStatsFilter(nil, nil, false)
+	assert.NoError(t, err)
+
+	data = newStatsData()
+	filter.Apply(&data)
+	assert.Len(t, data.Topics, 3)
+	assert.Nil(t, data.Topics[0].Channels[0].Clients)
+	assert.Equal(t, 2, data.Topics[0].Channels[0].ClientCount)
+	assert.Nil(t, data.Producers)
+}
+
+func TestProducer_GetStats_Filter(t *testing.T) {
+	var query url.Values
+
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+
+			// Pretend to be an nsqd which does not support filters.
+			w.Write([]byte(`{"status_code": 200, "data": {"topics": [
+				{"topic_name": "orders", "channels": [{"channel_name": "billing", "client_count": 1, "clients": [{"client_id": "foo"}]}]},
+				{"topic_name": "events", "channels": []}
+			]}}`))
+		}))
+
+	defer server.Close()
+
+	nsqdURL, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	host, strPort, err := net.SplitHostPort(nsqdURL.Host)
+	assert.NoError(t, err)
+
+	port, err := strconv.Atoi(strPort)
+	assert.NoError(t, err)
+
+	producer := Producer{BroadcastAddress: host, HTTPPort: port}
+
+	filter, err := NewStatsFilter([]string{"orders"}, nil, false)
+	assert.NoError(t, err)
+
+	stats, err := producer.GetStats(fetcher.DefaultClient, filter)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "orders", query.Get("topic"))
+	assert.Equal(t, "false", query.Get("include_clients"))
+	assert.Len(t, stats.Data.Topics, 1)
+	assert.Empty(t, stats.Data.Topics[0].Channels[0].Clients)
+	assert.Equal(t, 1, stats.Data.Topics[0].Channels[0].ClientCount)
+}
@@ -46,35 +46,50 @@ type MemoryStats struct {
 	GCTotalRuns       uint32 `json:"gc_total_runs"`
 }
 
-// StatsData is an embedded Stats type.
+// TopicStats wraps the /stats data of a topic, including the fields which are
+// only reported by recent versions of nsqd.
+type TopicStats struct {
+	nsqd.TopicStats
+	// MessageBytes is the total size of the messages published to the topic,
+	// or nil if not reported by nsqd.
+	MessageBytes *uint64 `json:"message_bytes,omitempty"`
+}
+
+// StatsData is an embedded Stats type. Producers are the clients publishing to
+// the node over TCP, which are only reported by recent versions of nsqd.
 type StatsData struct {
-	Version   string            `json:"version"`
-	Health    string            `json:"health"`
-	StartTime int64             `json:"start_time"`
-	Topics    []nsqd.TopicStats `json:"topics"`
-	Memory    MemoryStats       `json:"memory"`
+	Version   string             `json:"version"`
+	Health    string             `json:"health"`
+	StartTime int64              `json:"start_time"`
+	Topics    []TopicStats       `json:"topics"`
+	Memory    MemoryStats        `json:"memory"`
+	Producers []nsqd.ClientStats `json:"producers"`
 }
 
-// GetStats retrieves and parses the statistics of a nsqd.
-func (p Producer) GetStats() (Stats, error) {
+// GetStats retrieves and parses the statistics of a nsqd using the given client,
+// keeping only the topics, channels and clients selected by the filter.
+func (p Producer) GetStats(client *fetcher.Client, filter StatsFilter) (Stats, error) {
 	var stats Stats
 
-	fetcher := fetcher.NewFetcher(p.HTTPAddress())
-	body, err := fetcher.Fetch("stats?format=json")
+	f := client.NewFetcher(p.HTTPAddress())
+	body, err := f.Fetch(filter.Path())
 	if err != nil {
 		return stats, err
 	}
 
+	// Errors name the node, as those returned by the fetcher do.
 	err = json.Unmarshal(body, &stats)
 	if err != nil {
-		return stats, err
+		return stats, &fetcher.Error{Address: p.HTTPAddress(), Attempts: 1, Err: err}
 	}
 
 	if stats.StatusCode != 200 {
-		return stats, fmt.Errorf("response code was %d", stats.StatusCode)
+		return stats, &fetcher.Error{Address: p.HTTPAddress(), Attempts: 1, Err: fetcher.StatusError{StatusCode: stats.StatusCode}}
 	}
 
-	return stats, err
+	filter.Apply(&stats.Data)
+
+	return stats, nil
 }
 
 // HTTPAddress returns the broadcast address (e.g. 127.0.0.1) joined together
@@ -1,6 +1,7 @@
 package producer_test
 
 import (
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
 	. "github.com/ruimarinho/nsq-dogstatsd/producer"
 	"github.com/stretchr/testify/assert"
 )
@@ -37,12 +39,44 @@ func TestProducer_GetStats(t *testing.T) {
 	assert.NoError(t, err)
 
 	producer := Producer{BroadcastAddress: host, HTTPPort: port}
-	stats, err := producer.GetStats()
+	stats, err := producer.GetStats(fetcher.DefaultClient, StatsFilter{})
 
 	assert.NoError(t, err)
 	assert.NotNil(t, stats)
 }
 
+func TestProducer_GetStats_MessageBytes(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Only recent versions of nsqd report the size of messages.
+			w.Write([]byte(`{"status_code": 200, "data": {"topics": [
+				{"topic_name": "orders", "message_count": 2, "message_bytes": 512},
+				{"topic_name": "events", "message_count": 1}
+			]}}`))
+		}))
+
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	host, strPort, err := net.SplitHostPort(url.Host)
+	assert.NoError(t, err)
+
+	port, err := strconv.Atoi(strPort)
+	assert.NoError(t, err)
+
+	producer := Producer{BroadcastAddress: host, HTTPPort: port}
+	stats, err := producer.GetStats(fetcher.DefaultClient, StatsFilter{})
+	assert.NoError(t, err)
+
+	assert.Len(t, stats.Data.Topics, 2)
+	assert.Equal(t, "orders", stats.Data.Topics[0].TopicName)
+	assert.Equal(t, uint64(2), stats.Data.Topics[0].MessageCount)
+	assert.Equal(t, uint64(512), *stats.Data.Topics[0].MessageBytes)
+	assert.Nil(t, stats.Data.Topics[1].MessageBytes)
+}
+
 func TestProducer_GetStats_error(t *testing.T) {
 	server := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -61,7 +95,7 @@ func TestProducer_GetStats_error(t *testing.T) {
 	assert.NoError(t, convErr)
 
 	producer := Producer{BroadcastAddress: host, HTTPPort: port}
-	_, err := producer.GetStats()
+	_, err := producer.GetStats(fetcher.DefaultClient, StatsFilter{})
 
 	assert.Error(t, err)
 }
@@ -84,7 +118,34 @@ func TestProducer_GetStats_unmarshalError(t *testing.T) {
 	assert.NoError(t, convErr)
 
 	producer := Producer{BroadcastAddress: host, HTTPPort: port}
-	_, err := producer.GetStats()
+	_, err := producer.GetStats(fetcher.DefaultClient, StatsFilter{})
+
+	assert.EqualError(t, err, producer.HTTPAddress()+" - unexpected end of JSON input")
+}
+
+func TestProducer_GetStats_statusCodeError(t *testing.T) {
+	server := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status_code": 500}`))
+		}))
+
+	defer server.Close()
+
+	url, parseErr := url.Parse(server.URL)
+	assert.NoError(t, parseErr)
+
+	host, strPort, splitErr := net.SplitHostPort(url.Host)
+	assert.NoError(t, splitErr)
+
+	port, convErr := strconv.Atoi(strPort)
+	assert.NoError(t, convErr)
+
+	producer := Producer{BroadcastAddress: host, HTTPPort: port}
+	_, err := producer.GetStats(fetcher.DefaultClient, StatsFilter{})
+
+	assert.EqualError(t, err, producer.HTTPAddress()+" - response code was 500")
 
-	assert.EqualError(t, err, "unexpected end of JSON input")
+	var statusErr fetcher.StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, 500, statusErr.StatusCode)
 }
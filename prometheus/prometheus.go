@@ -0,0 +1,277 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	log "github.com/sirupsen/logrus"
+)
+
+// Collection modes of the exporter.
+const (
+	// CollectOnScrape collects metrics from nsqd whenever /metrics is requested.
+	CollectOnScrape = "scrape"
+	// CollectOnInterval serves the metrics cached from the last interval.
+	CollectOnInterval = "cache"
+)
+
+var (
+	invalidMetricNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	invalidLabelNameChars  = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	labelValueReplacer     = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Exporter serves collected metrics in the Prometheus text exposition format.
+type Exporter struct {
+	sync.Mutex
+	namespace string
+	tags      []string
+	metrics   []collector.Metric
+	totals    map[string]float64
+	collect   func()
+}
+
+// NewExporter returns an Exporter which prefixes metric names with the
+// namespace and adds the global tags as labels to every metric.
+func NewExporter(namespace string, tags []string) *Exporter {
+	return &Exporter{namespace: namespace, tags: tags, totals: map[string]float64{}}
+}
+
+// SetCollect sets a function to be called on every scrape to refresh the
+// metrics before they are served.
+func (e *Exporter) SetCollect(collect func()) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.collect = collect
+}
+
+// Collect refreshes the metrics if collecting on scrape.
+func (e *Exporter) Collect() {
+	e.Lock()
+	collect := e.collect
+	e.Unlock()
+
+	if collect != nil {
+		collect()
+	}
+}
+
+// Send replaces the served metrics with the given ones. Counts hold deltas
+// between collections and are therefore accumulated into running totals, which
+// are exposed as Prometheus counters. Totals of series missing from the update
+// are discarded.
+func (e *Exporter) Send(metrics []collector.Metric) error {
+	e.Lock()
+	defer e.Unlock()
+
+	totals := map[string]float64{}
+
+	for _, m := range metrics {
+		if m.Type != collector.CountType {
+			continue
+		}
+
+		key := seriesKey(m)
+		totals[key] = e.totals[key] + m.Value
+	}
+
+	e.metrics = metrics
+	e.totals = totals
+
+	return nil
+}
+
+// Flush is a no-op since metrics are served on request.
+func (e *Exporter) Flush() error {
+	return nil
+}
+
+// Close is a no-op since the exporter does not hold any resources.
+func (e *Exporter) Close() error {
+	return nil
+}
+
+// ServeHTTP renders the current metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	NewHandler(e).ServeHTTP(w, r)
+}
+
+// family holds the samples of a metric.
+type family struct {
+	kind    string
+	help    string
+	samples []string
+}
+
+func (e *Exporter) families(families map[string]*family) {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, m := range e.metrics {
+		name := e.MetricName(m)
+		value := m.Value
+		kind := "gauge"
+
+		switch m.Type {
+		case collector.CountType:
+			kind = "counter"
+			value = e.totals[seriesKey(m)]
+		case collector.ServiceCheckType:
+			value = 0
+			if collector.ServiceCheckStatus(m.Value) == collector.ServiceCheckOK {
+				value = 1
+			}
+		}
+
+		if _, ok := families[name]; !ok {
+			families[name] = &family{kind: kind, help: e.help(m)}
+		}
+
+		families[name].samples = append(families[name].samples, fmt.Sprintf("%s%s %s", name, e.Labels(m.Tags), strconv.FormatFloat(value, 'g', -1, 64)))
+	}
+}
+
+// help describes a metric by its DogStatsD name.
+func (e *Exporter) help(m collector.Metric) string {
+	name := m.Name
+	if e.namespace != "" {
+		name = fmt.Sprintf("%s.%s", e.namespace, name)
+	}
+
+	switch m.Type {
+	case collector.CountType:
+		return fmt.Sprintf("Count %s, accumulated since nsq_to_dogstatsd started.", name)
+	case collector.ServiceCheckType:
+		return fmt.Sprintf("Service check %s, 1 if OK and 0 otherwise.", name)
+	default:
+		return fmt.Sprintf("Gauge %s.", name)
+	}
+}
+
+// Handler serves the metrics of one or more exporters (e.g. one per cluster)
+// on a single endpoint, merging metrics sharing the same name.
+type Handler struct {
+	sync.Mutex
+	exporters []*Exporter
+}
+
+// NewHandler returns a Handler serving the metrics of the given exporters.
+func NewHandler(exporters ...*Exporter) *Handler {
+	return &Handler{exporters: exporters}
+}
+
+// SetExporters replaces the exporters whose metrics are served.
+func (h *Handler) SetExporters(exporters ...*Exporter) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.exporters = exporters
+}
+
+// ServeHTTP collects metrics (if collecting on scrape) and renders them.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	exporters := h.exporters
+	h.Unlock()
+
+	families := map[string]*family{}
+
+	for _, e := range exporters {
+		e.Collect()
+		e.families(families)
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buffer, "# HELP %s %s\n", name, families[name].help)
+		fmt.Fprintf(&buffer, "# TYPE %s %s\n", name, families[name].kind)
+
+		for _, sample := range families[name].samples {
+			buffer.WriteString(sample)
+			buffer.WriteString("\n")
+		}
+	}
+
+	log.WithField("families", len(names)).Debug("rendered prometheus metrics")
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buffer.Bytes())
+}
+
+// MetricName converts the dotted name of a metric (e.g. topic.depth) into a
+// valid Prometheus metric name prefixed with the namespace (e.g. nsq_topic_depth).
+// Counters are suffixed with _total and service checks, whose value is 1 if OK
+// and 0 otherwise, with _up.
+func (e *Exporter) MetricName(m collector.Metric) string {
+	name := m.Name
+	if e.namespace != "" {
+		name = fmt.Sprintf("%s_%s", e.namespace, name)
+	}
+
+	name = invalidMetricNameChars.ReplaceAllString(name, "_")
+
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	switch m.Type {
+	case collector.CountType:
+		name += "_total"
+	case collector.ServiceCheckType:
+		name += "_up"
+	}
+
+	return name
+}
+
+// Labels converts DogStatsD key:value tags, followed by the global tags, into a
+// Prometheus label set. Tags without a value are converted to a label with the
+// value "true". When a label is repeated, its first value is kept.
+func (e *Exporter) Labels(tags []string) string {
+	seen := map[string]bool{}
+	labels := []string{}
+
+	for _, tag := range append(append([]string{}, tags...), e.tags...) {
+		name, value := tag, "true"
+		if i := strings.Index(tag, ":"); i >= 0 {
+			name, value = tag[:i], tag[i+1:]
+		}
+
+		name = invalidLabelNameChars.ReplaceAllString(name, "_")
+		if name == "" || seen[name] {
+			continue
+		}
+
+		if name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+
+		seen[name] = true
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, name, labelValueReplacer.Replace(value)))
+	}
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(labels, ","))
+}
+
+func seriesKey(m collector.Metric) string {
+	return fmt.Sprintf("%s|%s", m.Name, strings.Join(m.Tags, ","))
+}
@@ -0,0 +1,115 @@
+package prometheus_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	. "github.com/ruimarinho/nsq-dogstatsd/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExporter_MetricName(t *testing.T) {
+	exporter := NewExporter("nsq", nil)
+
+	var tests = []struct {
+		metric   collector.Metric
+		expected string
+	}{
+		{collector.Metric{Name: "topic.depth", Type: collector.GaugeType}, "nsq_topic_depth"},
+		{collector.Metric{Name: "topic.e2e-latency", Type: collector.GaugeType}, "nsq_topic_e2e_latency"},
+		{collector.Metric{Name: "topic.messages", Type: collector.CountType}, "nsq_topic_messages_total"},
+		{collector.Metric{Name: "node.can_connect", Type: collector.ServiceCheckType}, "nsq_node_can_connect_up"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, exporter.MetricName(tt.metric))
+	}
+}
+
+func TestExporter_Labels(t *testing.T) {
+	exporter := NewExporter("nsq", []string{"environment:production", "node:global", "canary"})
+
+	assert.Equal(t, `{node="foo",topic="bar\"baz",client_id="1",environment="production",canary="true"}`, exporter.Labels([]string{"node:foo", `topic:bar"baz`, "client-id:1"}))
+	assert.Equal(t, "", NewExporter("nsq", nil).Labels(nil))
+}
+
+func TestExporter_ServeHTTP(t *testing.T) {
+	exporter := NewExporter("nsq", []string{"environment:test"})
+
+	collections := 0
+	exporter.SetCollect(func() {
+		collections++
+
+		exporter.Send([]collector.Metric{
+			collector.NewMetric("topic.depth", 3, []string{"node:foo", "topic:bar"}),
+			collector.NewMetric("topic.depth", 1, []string{"node:foo", "topic:baz"}),
+			{Name: "topic.messages", Type: collector.CountType, Value: 5, Rate: 1, Tags: []string{"node:foo", "topic:bar"}},
+			collector.NewServiceCheckMetric("node.health", collector.ServiceCheckCritical, "NOK", []string{"node:foo"}),
+			collector.NewServiceCheckMetric("node.can_connect", collector.ServiceCheckOK, "", []string{"node:foo"}),
+		})
+	})
+
+	for _, expected := range []string{"5", "10"} {
+		recorder := httptest.NewRecorder()
+		exporter.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		body, err := ioutil.ReadAll(recorder.Body)
+		assert.NoError(t, err)
+
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `# HELP nsq_node_can_connect_up Service check nsq.node.can_connect, 1 if OK and 0 otherwise.
+# TYPE nsq_node_can_connect_up gauge
+nsq_node_can_connect_up{node="foo",environment="test"} 1
+# HELP nsq_node_health_up Service check nsq.node.health, 1 if OK and 0 otherwise.
+# TYPE nsq_node_health_up gauge
+nsq_node_health_up{node="foo",environment="test"} 0
+# HELP nsq_topic_depth Gauge nsq.topic.depth.
+# TYPE nsq_topic_depth gauge
+nsq_topic_depth{node="foo",topic="bar",environment="test"} 3
+nsq_topic_depth{node="foo",topic="baz",environment="test"} 1
+# HELP nsq_topic_messages_total Count nsq.topic.messages, accumulated since nsq_to_dogstatsd started.
+# TYPE nsq_topic_messages_total counter
+nsq_topic_messages_total{node="foo",topic="bar",environment="test"} `+expected+"\n", string(body))
+	}
+
+	assert.Equal(t, 2, collections)
+}
+
+func TestExporter_Send_DiscardsMissingTotals(t *testing.T) {
+	exporter := NewExporter("nsq", nil)
+	count := collector.Metric{Name: "topic.messages", Type: collector.CountType, Value: 5, Rate: 1}
+
+	exporter.Send([]collector.Metric{count})
+	exporter.Send([]collector.Metric{})
+	exporter.Send([]collector.Metric{count})
+
+	recorder := httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "# HELP nsq_topic_messages_total Count nsq.topic.messages, accumulated since nsq_to_dogstatsd started.\n# TYPE nsq_topic_messages_total counter\nnsq_topic_messages_total 5\n", recorder.Body.String())
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	first := NewExporter("nsq", []string{"cluster:first"})
+	first.Send([]collector.Metric{collector.NewMetric("topic.depth", 1, []string{"node:foo"})})
+
+	second := NewExporter("nsq", []string{"cluster:second"})
+	second.Send([]collector.Metric{
+		collector.NewMetric("topic.depth", 2, []string{"node:bar"}),
+		collector.NewMetric("topic.backend_depth", 3, []string{"node:bar"}),
+	})
+
+	recorder := httptest.NewRecorder()
+	NewHandler(first, second).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, `# HELP nsq_topic_backend_depth Gauge nsq.topic.backend_depth.
+# TYPE nsq_topic_backend_depth gauge
+nsq_topic_backend_depth{node="bar",cluster="second"} 3
+# HELP nsq_topic_depth Gauge nsq.topic.depth.
+# TYPE nsq_topic_depth gauge
+nsq_topic_depth{node="foo",cluster="first"} 1
+nsq_topic_depth{node="bar",cluster="second"} 2
+`, recorder.Body.String())
+}
@@ -1,6 +1,8 @@
 package resolver
 
 import (
+	"net"
+	"strconv"
 	"sync"
 
 	"github.com/ruimarinho/nsq-dogstatsd/collector"
@@ -12,9 +14,14 @@ import (
 // ResolveNodes queries NSQD and NSQLookupd servers to retrieve information about producer nodes.
 // Duplicate nodes are skipped, so if a NSQD address is passed and the same NSQD is found on a
 // given NSQLookupd, it will only be used once.
-func ResolveNodes(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]producer.Producer, error) {
+//
+// When the client uses TLS, NSQD nodes given directly keep the port of their given address, as
+// the HTTPS port of a NSQD is not advertised. Nodes are then identified by their broadcast address
+// and TCP port instead, and a node given directly is preferred over the same node found on a
+// NSQLookupd with its HTTP port.
+func ResolveNodes(client *fetcher.Client, nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]producer.Producer, error) {
 	var wg sync.WaitGroup
-	var producerChan = make(chan producer.Producer)
+	var producerChan = make(chan resolvedNode)
 	var errChan = make(chan error)
 
 	for _, address := range nsqdHTTPAddresses {
@@ -23,8 +30,7 @@ func ResolveNodes(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]
 		go func(address string) {
 			defer wg.Done()
 
-			fetcher := fetcher.NewFetcher(address)
-			collector := collector.NSQDCollector{Fetcher: fetcher}
+			collector := collector.NSQDCollector{Fetcher: client.NewFetcher(address)}
 			info, err := collector.GetInfo()
 
 			if err != nil {
@@ -32,7 +38,14 @@ func ResolveNodes(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]
 				return
 			}
 
-			producerChan <- info.Producer
+			if client.UsesTLS() {
+				if info.Producer.HTTPPort, err = port(address); err != nil {
+					errChan <- err
+					return
+				}
+			}
+
+			producerChan <- resolvedNode{Producer: info.Producer, direct: true}
 		}(address)
 	}
 
@@ -44,8 +57,7 @@ func ResolveNodes(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]
 
 			log.WithField("address", address).Debug("resolving nodes from nsqlookupd")
 
-			fetcher := fetcher.NewFetcher(address)
-			collector := collector.NSQDCollector{Fetcher: fetcher}
+			collector := collector.NSQDCollector{Fetcher: client.NewFetcher(address)}
 			nodes, err := collector.GetNodes()
 
 			if err != nil {
@@ -54,7 +66,7 @@ func ResolveNodes(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]
 			}
 
 			for _, producer := range nodes.Data.Producers {
-				producerChan <- producer
+				producerChan <- resolvedNode{Producer: producer}
 			}
 		}(address)
 	}
@@ -65,32 +77,111 @@ func ResolveNodes(nsqdHTTPAddresses []string, lookupdHTTPAddresses []string) ([]
 		close(producerChan)
 	}()
 
-	addresses := map[string]bool{}
+	indexes := map[string]int{}
+	direct := map[string]bool{}
 	producers := []producer.Producer{}
 
 	for {
 		select {
-		case producer := <-producerChan:
-			if producer.BroadcastAddress == "" {
+		case node := <-producerChan:
+			if node.BroadcastAddress == "" {
 				return producers, nil
 			}
 
-			if addresses[producer.HTTPAddress()] {
-				log.WithField("address", producer.HTTPAddress()).Debug("skipping duplicate address")
+			key := nodeKey(client, node.Producer)
+
+			if i, ok := indexes[key]; ok {
+				if node.direct && !direct[key] {
+					log.WithFields(log.Fields{"address": node.HTTPAddress(), "replaced": producers[i].HTTPAddress()}).Debug("replacing address found on nsqlookupd")
+
+					producers[i] = node.Producer
+					direct[key] = true
+
+					continue
+				}
+
+				log.WithField("address", node.HTTPAddress()).Debug("skipping duplicate address")
 				continue
 			}
 
-			addresses[producer.HTTPAddress()] = true
+			indexes[key] = len(producers)
+			direct[key] = node.direct
 
-			log.WithField("address", producer.HTTPAddress()).Info("added address")
+			log.WithField("address", node.HTTPAddress()).Info("added address")
 
-			producers = append(producers, producer)
+			producers = append(producers, node.Producer)
 		case err := <-errChan:
 			if err == nil {
 				continue
 			}
 
+			// Drain any pending results so that lookups still in progress do not
+			// block forever and leak their goroutines.
+			go func() {
+				for range producerChan {
+				}
+			}()
+
+			go func() {
+				for range errChan {
+				}
+			}()
+
 			return producers, err
 		}
 	}
 }
+
+// resolvedNode is a node resolved either from its given address or from a
+// NSQLookupd.
+type resolvedNode struct {
+	producer.Producer
+	direct bool
+}
+
+// DiffNodes compares the currently known producers against a freshly resolved
+// set and returns which producers were added and which were removed. Producers
+// are identified as by the deduplication performed by ResolveNodes with the
+// same client.
+func DiffNodes(client *fetcher.Client, current []producer.Producer, resolved []producer.Producer) (added []producer.Producer, removed []producer.Producer) {
+	currentKeys := map[string]bool{}
+	for _, p := range current {
+		currentKeys[nodeKey(client, p)] = true
+	}
+
+	resolvedKeys := map[string]bool{}
+	for _, p := range resolved {
+		resolvedKeys[nodeKey(client, p)] = true
+
+		if !currentKeys[nodeKey(client, p)] {
+			added = append(added, p)
+		}
+	}
+
+	for _, p := range current {
+		if !resolvedKeys[nodeKey(client, p)] {
+			removed = append(removed, p)
+		}
+	}
+
+	return added, removed
+}
+
+// nodeKey returns the key identifying a node, which is its HTTP address unless
+// the client uses TLS, in which case it is its broadcast address and TCP port.
+func nodeKey(client *fetcher.Client, p producer.Producer) string {
+	if client.UsesTLS() {
+		return net.JoinHostPort(p.BroadcastAddress, strconv.FormatInt(p.TCPPort, 10))
+	}
+
+	return p.HTTPAddress()
+}
+
+func port(address string) (int, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(port)
+}
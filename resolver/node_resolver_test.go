@@ -1,11 +1,14 @@
 package resolver_test
 
 import (
+	"crypto/tls"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
 	. "github.com/ruimarinho/nsq-dogstatsd/resolver"
 	"github.com/stretchr/testify/assert"
 )
@@ -32,7 +35,7 @@ func TestResolveNodes_NSQLookupdAddresses(t *testing.T) {
 	nsqlookupdURL, err := url.Parse(nsqlookupdServer.URL)
 	assert.Nil(t, err)
 
-	producers, err := ResolveNodes([]string{}, []string{nsqlookupdURL.Host})
+	producers, err := ResolveNodes(fetcher.DefaultClient, []string{}, []string{nsqlookupdURL.Host})
 	assert.Nil(t, err)
 	assert.Len(t, producers, 1)
 }
@@ -48,7 +51,7 @@ func TestResolveNodes_NSQLookupdAddresses_Error(t *testing.T) {
 	nsqlookupdURL, parseErr := url.Parse(nsqlookupdServer.URL)
 	assert.Nil(t, parseErr)
 
-	_, err := ResolveNodes([]string{}, []string{nsqlookupdURL.Host})
+	_, err := ResolveNodes(fetcher.DefaultClient, []string{}, []string{nsqlookupdURL.Host})
 	assert.NotNil(t, err)
 }
 
@@ -71,11 +74,39 @@ func TestResolveNodes_NSQDAddresses(t *testing.T) {
 	nsqdURL, err := url.Parse(nsqdServer.URL)
 	assert.Nil(t, err)
 
-	producers, err := ResolveNodes([]string{nsqdURL.Host}, []string{})
+	producers, err := ResolveNodes(fetcher.DefaultClient, []string{nsqdURL.Host}, []string{})
 	assert.Nil(t, err)
 	assert.Len(t, producers, 1)
 }
 
+func TestResolveNodes_NSQDAddresses_TLS(t *testing.T) {
+	nsqdServer := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{
+              "status_code": 200,
+              "status_txt": "OK",
+              "data": {
+                "broadcast_address": "127.0.0.1",
+                "hostname": "58d493c00ddc",
+                "http_port": 4151
+              }
+            }`))
+		}))
+
+	defer nsqdServer.Close()
+
+	nsqdURL, err := url.Parse(nsqdServer.URL)
+	assert.Nil(t, err)
+
+	client := fetcher.NewClient(nsqdServer.Client().Transport.(*http.Transport).TLSClientConfig, fetcher.DefaultClientOptions)
+
+	// The advertised HTTP port is replaced by the port of the HTTPS address.
+	producers, err := ResolveNodes(client, []string{nsqdURL.Host}, []string{})
+	assert.Nil(t, err)
+	assert.Len(t, producers, 1)
+	assert.Equal(t, nsqdURL.Host, producers[0].HTTPAddress())
+}
+
 func TestResolveNodes_NSQDAddresses_Error(t *testing.T) {
 	nsqdServer := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +118,7 @@ func TestResolveNodes_NSQDAddresses_Error(t *testing.T) {
 	nsqdURL, parseErr := url.Parse(nsqdServer.URL)
 	assert.Nil(t, parseErr)
 
-	_, err := ResolveNodes([]string{nsqdURL.Host, nsqdURL.Host}, []string{})
+	_, err := ResolveNodes(fetcher.DefaultClient, []string{nsqdURL.Host, nsqdURL.Host}, []string{})
 	assert.NotNil(t, err)
 }
 
@@ -110,7 +141,104 @@ func TestResolveNodes_NSQDAddresses_Duplicates_Ignored(t *testing.T) {
 	nsqdURL, err := url.Parse(nsqdServer.URL)
 	assert.Nil(t, err)
 
-	producers, err := ResolveNodes([]string{nsqdURL.Host, nsqdURL.Host}, []string{})
+	producers, err := ResolveNodes(fetcher.DefaultClient, []string{nsqdURL.Host, nsqdURL.Host}, []string{})
+	assert.Nil(t, err)
+	assert.Len(t, producers, 1)
+}
+
+func TestResolveNodes_Duplicates_TLS(t *testing.T) {
+	nsqdServer := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{
+              "status_code": 200,
+              "status_txt": "OK",
+              "data": {
+                "broadcast_address": "127.0.0.1",
+                "hostname": "58d493c00ddc",
+                "http_port": 4151,
+                "tcp_port": 4150
+              }
+            }`))
+		}))
+
+	defer nsqdServer.Close()
+
+	nsqlookupdServer := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{
+              "status_code": 200,
+              "status_txt": "OK",
+              "data": {
+                "producers": [
+                  {
+                    "broadcast_address": "127.0.0.1",
+                    "http_port": 4151,
+                    "tcp_port": 4150
+                  }
+                ]
+              }
+            }`))
+		}))
+
+	defer nsqlookupdServer.Close()
+
+	nsqdURL, err := url.Parse(nsqdServer.URL)
+	assert.Nil(t, err)
+
+	nsqlookupdURL, err := url.Parse(nsqlookupdServer.URL)
+	assert.Nil(t, err)
+
+	client := fetcher.NewClient(nsqdServer.Client().Transport.(*http.Transport).TLSClientConfig, fetcher.DefaultClientOptions)
+
+	// The node found on nsqlookupd with its HTTP port is the node given with its
+	// HTTPS address.
+	producers, err := ResolveNodes(client, []string{nsqdURL.Host}, []string{nsqlookupdURL.Host})
 	assert.Nil(t, err)
 	assert.Len(t, producers, 1)
+	assert.Equal(t, nsqdURL.Host, producers[0].HTTPAddress())
+}
+
+func TestDiffNodes(t *testing.T) {
+	current := []producer.Producer{
+		{BroadcastAddress: "10.0.0.1", HTTPPort: 4151},
+		{BroadcastAddress: "10.0.0.2", HTTPPort: 4151},
+	}
+
+	resolved := []producer.Producer{
+		{BroadcastAddress: "10.0.0.2", HTTPPort: 4151},
+		{BroadcastAddress: "10.0.0.3", HTTPPort: 4151},
+	}
+
+	added, removed := DiffNodes(fetcher.DefaultClient, current, resolved)
+
+	assert.Equal(t, []producer.Producer{{BroadcastAddress: "10.0.0.3", HTTPPort: 4151}}, added)
+	assert.Equal(t, []producer.Producer{{BroadcastAddress: "10.0.0.1", HTTPPort: 4151}}, removed)
+}
+
+func TestDiffNodes_Unchanged(t *testing.T) {
+	current := []producer.Producer{{BroadcastAddress: "10.0.0.1", HTTPPort: 4151}}
+
+	added, removed := DiffNodes(fetcher.DefaultClient, current, current)
+
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestDiffNodes_TLS(t *testing.T) {
+	client := fetcher.NewClient(&tls.Config{}, fetcher.DefaultClientOptions)
+
+	// The same node given directly and found on a nsqlookupd differs only by
+	// its HTTP port.
+	current := []producer.Producer{{BroadcastAddress: "10.0.0.1", HTTPPort: 4152, TCPPort: 4150}}
+	resolved := []producer.Producer{{BroadcastAddress: "10.0.0.1", HTTPPort: 4151, TCPPort: 4150}}
+
+	added, removed := DiffNodes(client, current, resolved)
+
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	added, removed = DiffNodes(fetcher.DefaultClient, current, resolved)
+
+	assert.Equal(t, resolved, added)
+	assert.Equal(t, current, removed)
 }
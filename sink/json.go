@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+)
+
+// JSONSink writes metrics as JSON, one metric per line.
+type JSONSink struct {
+	sync.Mutex
+	namespace string
+	tags      []string
+	writer    io.Writer
+	closer    io.Closer
+}
+
+type jsonMetric struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Value   float64  `json:"value"`
+	Rate    float64  `json:"rate,omitempty"`
+	Tags    []string `json:"tags"`
+	Message string   `json:"message,omitempty"`
+}
+
+// NewJSONSink returns a sink writing metrics to w, prefixing their names with
+// the namespace and appending the global tags, like the DogStatsD client does.
+func NewJSONSink(w io.Writer, namespace string, tags []string) *JSONSink {
+	return &JSONSink{writer: w, namespace: namespace, tags: tags}
+}
+
+// NewFileSink returns a JSON sink appending metrics to the file at path,
+// creating it if needed.
+func NewFileSink(path string, namespace string, tags []string) (*JSONSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewJSONSink(file, namespace, tags)
+	s.closer = file
+
+	return s, nil
+}
+
+// Send writes metrics to the underlying writer.
+func (s *JSONSink) Send(metrics []collector.Metric) error {
+	s.Lock()
+	defer s.Unlock()
+
+	encoder := json.NewEncoder(s.writer)
+
+	for _, m := range metrics {
+		name := m.Name
+		if s.namespace != "" {
+			name = fmt.Sprintf("%s.%s", s.namespace, m.Name)
+		}
+
+		err := encoder.Encode(jsonMetric{
+			Name:    name,
+			Type:    m.Type,
+			Value:   m.Value,
+			Rate:    m.Rate,
+			Tags:    append(append([]string{}, m.Tags...), s.tags...),
+			Message: m.Message,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush is a no-op since metrics are written as they are sent.
+func (s *JSONSink) Flush() error {
+	return nil
+}
+
+// Close closes the underlying file, if any.
+func (s *JSONSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
@@ -0,0 +1,59 @@
+package sink_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	. "github.com/ruimarinho/nsq-dogstatsd/sink"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSink_Send(t *testing.T) {
+	var buffer bytes.Buffer
+
+	s := NewJSONSink(&buffer, "nsq", []string{"env:test"})
+	err := s.Send([]collector.Metric{
+		collector.NewMetric("topic.depth", 1, []string{"node:foo"}),
+		collector.NewServiceCheckMetric("node.health", collector.ServiceCheckCritical, "NOK", []string{"node:foo"}),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"nsq.topic.depth","type":"gauge","value":1,"rate":1,"tags":["node:foo","env:test"]}
+{"name":"nsq.node.health","type":"service_check","value":2,"tags":["node:foo","env:test"],"message":"NOK"}
+`, buffer.String())
+
+	assert.NoError(t, s.Flush())
+	assert.NoError(t, s.Close())
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsq-dogstatsd")
+	assert.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics.json")
+
+	for i := 0; i < 2; i++ {
+		s, err := NewFileSink(path, "", nil)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Send([]collector.Metric{collector.NewMetric("topic.depth", 1, []string{})}))
+		assert.NoError(t, s.Close())
+	}
+
+	body, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"topic.depth","type":"gauge","value":1,"rate":1,"tags":[]}
+{"name":"topic.depth","type":"gauge","value":1,"rate":1,"tags":[]}
+`, string(body))
+}
+
+func TestFileSink_Error(t *testing.T) {
+	_, err := NewFileSink("/nonexistent/metrics.json", "", nil)
+
+	assert.Error(t, err)
+}
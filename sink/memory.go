@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"sync"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+)
+
+// MemorySink keeps every batch of metrics it receives in memory, which is
+// mostly useful for testing.
+type MemorySink struct {
+	sync.Mutex
+	Batches [][]collector.Metric
+	Flushes int
+	Closed  bool
+}
+
+// NewMemorySink returns an empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// Send records the batch of metrics.
+func (s *MemorySink) Send(metrics []collector.Metric) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Batches = append(s.Batches, metrics)
+
+	return nil
+}
+
+// Flush records that the sink was flushed.
+func (s *MemorySink) Flush() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Flushes++
+
+	return nil
+}
+
+// Close records that the sink was closed.
+func (s *MemorySink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Closed = true
+
+	return nil
+}
+
+// Metrics returns all metrics received so far.
+func (s *MemorySink) Metrics() []collector.Metric {
+	s.Lock()
+	defer s.Unlock()
+
+	metrics := []collector.Metric{}
+	for _, batch := range s.Batches {
+		metrics = append(metrics, batch...)
+	}
+
+	return metrics
+}
@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+)
+
+// Sink publishes collected metrics to a destination, such as DogStatsD.
+type Sink interface {
+	// Send publishes a batch of metrics collected during an interval.
+	Send(metrics []collector.Metric) error
+	// Flush forces any buffered metrics to be published.
+	Flush() error
+	// Close flushes and releases any resources held by the sink.
+	Close() error
+}
+
+// Stats holds the number of bytes and packets sent and dropped by a sink.
+type Stats struct {
+	BytesSent      uint64
+	PacketsSent    uint64
+	BytesDropped   uint64
+	PacketsDropped uint64
+}
+
+// Add returns the sum of both stats.
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		BytesSent:      s.BytesSent + other.BytesSent,
+		PacketsSent:    s.PacketsSent + other.PacketsSent,
+		BytesDropped:   s.BytesDropped + other.BytesDropped,
+		PacketsDropped: s.PacketsDropped + other.PacketsDropped,
+	}
+}
+
+// Reporter is implemented by sinks which keep track of the packets they send.
+type Reporter interface {
+	// Stats returns the bytes and packets sent and dropped since the previous
+	// call.
+	Stats() Stats
+}
+
+// MultiSink fans out metrics to several sinks.
+type MultiSink struct {
+	Sinks []Sink
+}
+
+// NewMultiSink returns a sink which publishes metrics to all given sinks.
+func NewMultiSink(sinks ...Sink) *MultiSink {
+	return &MultiSink{Sinks: sinks}
+}
+
+// Send publishes metrics to every sink, even if some of them fail.
+func (m *MultiSink) Send(metrics []collector.Metric) error {
+	return m.each(func(s Sink) error { return s.Send(metrics) })
+}
+
+// Flush flushes every sink, even if some of them fail.
+func (m *MultiSink) Flush() error {
+	return m.each(func(s Sink) error { return s.Flush() })
+}
+
+// Close closes every sink, even if some of them fail.
+func (m *MultiSink) Close() error {
+	return m.each(func(s Sink) error { return s.Close() })
+}
+
+// Stats returns the sum of the stats of every sink reporting them.
+func (m *MultiSink) Stats() Stats {
+	var stats Stats
+
+	for _, s := range m.Sinks {
+		if reporter, ok := s.(Reporter); ok {
+			stats = stats.Add(reporter.Stats())
+		}
+	}
+
+	return stats
+}
+
+func (m *MultiSink) each(fn func(s Sink) error) error {
+	var messages []string
+
+	for _, s := range m.Sinks {
+		if err := fn(s); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+
+	return nil
+}
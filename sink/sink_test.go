@@ -0,0 +1,74 @@
+package sink_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	. "github.com/ruimarinho/nsq-dogstatsd/sink"
+	"github.com/stretchr/testify/assert"
+)
+
+type errorSink struct{}
+
+func (s errorSink) Send(metrics []collector.Metric) error {
+	return errors.New("send error")
+}
+
+func (s errorSink) Flush() error {
+	return errors.New("flush error")
+}
+
+func (s errorSink) Close() error {
+	return errors.New("close error")
+}
+
+func TestMultiSink(t *testing.T) {
+	first := NewMemorySink()
+	second := NewMemorySink()
+	multi := NewMultiSink(first, second)
+
+	metrics := []collector.Metric{collector.NewMetric("topic.depth", 1, []string{"node:foo"})}
+
+	assert.NoError(t, multi.Send(metrics))
+	assert.NoError(t, multi.Flush())
+	assert.NoError(t, multi.Close())
+
+	for _, s := range []*MemorySink{first, second} {
+		assert.Equal(t, metrics, s.Metrics())
+		assert.Equal(t, 1, s.Flushes)
+		assert.True(t, s.Closed)
+	}
+}
+
+func TestMultiSink_Errors(t *testing.T) {
+	memory := NewMemorySink()
+	multi := NewMultiSink(errorSink{}, memory, errorSink{})
+
+	metrics := []collector.Metric{collector.NewMetric("topic.depth", 1, []string{"node:foo"})}
+
+	assert.EqualError(t, multi.Send(metrics), "send error; send error")
+	assert.EqualError(t, multi.Flush(), "flush error; flush error")
+	assert.EqualError(t, multi.Close(), "close error; close error")
+
+	assert.Equal(t, metrics, memory.Metrics())
+}
+
+type reporterSink struct {
+	*MemorySink
+	stats Stats
+}
+
+func (s reporterSink) Stats() Stats {
+	return s.stats
+}
+
+func TestMultiSink_Stats(t *testing.T) {
+	multi := NewMultiSink(
+		reporterSink{NewMemorySink(), Stats{BytesSent: 10, PacketsSent: 1}},
+		NewMemorySink(),
+		reporterSink{NewMemorySink(), Stats{BytesSent: 20, PacketsSent: 2, BytesDropped: 5, PacketsDropped: 1}},
+	)
+
+	assert.Equal(t, Stats{BytesSent: 30, PacketsSent: 3, BytesDropped: 5, PacketsDropped: 1}, multi.Stats())
+}
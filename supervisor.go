@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/admin"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/config"
+	"github.com/ruimarinho/nsq-dogstatsd/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// supervisor runs a metrics loop for each cluster and applies configuration
+// reloads to them, starting and stopping loops as clusters are added or
+// removed.
+type supervisor struct {
+	loops             map[string]*metricsLoop
+	handler           *prometheus.Handler
+	prometheusAddress string
+	admin             *admin.Handler
+	httpAddress       string
+	doneChan          chan bool
+	errChan           chan error
+}
+
+func newSupervisor(doneChan chan bool, errChan chan error) *supervisor {
+	return &supervisor{
+		loops:    map[string]*metricsLoop{},
+		handler:  prometheus.NewHandler(),
+		admin:    admin.NewHandler(0),
+		doneChan: doneChan,
+		errChan:  errChan,
+	}
+}
+
+// Apply validates the configuration and applies it to the running loops. If the
+// configuration is invalid, an error is returned and the loops are unchanged.
+func (s *supervisor) Apply(cfg config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if len(s.loops) > 0 && cfg.Interval.Seconds() == 0 && !cfg.CollectsOnScrape() {
+		return errors.New("--interval can not be removed while running")
+	}
+
+	// The level is set before touching any loop, so that it applies to what
+	// they log while starting or reloading.
+	setLogLevel(cfg.Verbose)
+
+	reloads := map[string]reload{}
+	names := []string{}
+
+	for _, cluster := range cfg.GetClusters() {
+		opts, err := newOptions(cfg, cluster)
+		if err == nil {
+			var r reload
+			if r, err = newReload(opts); err == nil {
+				reloads[cluster.Name] = r
+				names = append(names, cluster.Name)
+
+				continue
+			}
+		}
+
+		for _, r := range reloads {
+			r.close()
+		}
+
+		return err
+	}
+
+	exporters := []*prometheus.Exporter{}
+	clusters := []admin.Cluster{}
+
+	// Clusters collected on scrape have no interval to be ready within.
+	interval := cfg.Interval
+	if cfg.CollectsOnScrape() {
+		interval = 0
+	}
+
+	for _, name := range names {
+		r := reloads[name]
+
+		// Telemetry is exported after the metrics it describes, as they are
+		// only collected when the latter are scraped.
+		for _, e := range []*prometheus.Exporter{r.exporter, r.telemetryExporter} {
+			if e != nil {
+				exporters = append(exporters, e)
+			}
+		}
+
+		if loop, ok := s.loops[name]; ok {
+			loop.Reload(r)
+			clusters = append(clusters, admin.Cluster{Name: name, Interval: interval, Recorder: loop.recorder})
+
+			continue
+		}
+
+		log.WithField("cluster", name).Debug("collecting metrics for cluster")
+
+		loop := newMetricsLoop(r, s.errChan)
+		s.loops[name] = loop
+		clusters = append(clusters, admin.Cluster{Name: name, Interval: interval, Recorder: loop.recorder})
+
+		go loop.run(s.doneChan)
+	}
+
+	for name, loop := range s.loops {
+		if _, ok := reloads[name]; !ok {
+			log.WithField("cluster", name).Info("no longer collecting metrics for cluster")
+
+			loop.Stop()
+			delete(s.loops, name)
+		}
+	}
+
+	s.handler.SetExporters(exporters...)
+
+	if len(exporters) > 0 {
+		if s.prometheusAddress == "" {
+			s.prometheusAddress = cfg.PrometheusAddress
+
+			go servePrometheus(s.prometheusAddress, s.handler, s.errChan)
+		} else if s.prometheusAddress != cfg.PrometheusAddress {
+			log.WithField("address", s.prometheusAddress).Warn("changing the prometheus address requires a restart")
+		}
+	}
+
+	s.admin.SetClusters(clusters...)
+	s.admin.SetReadyIntervals(cfg.ReadyIntervals)
+
+	if cfg.HTTPAddress != "" {
+		if s.httpAddress == "" {
+			s.httpAddress = cfg.HTTPAddress
+
+			go serveAdmin(s.httpAddress, s.admin, s.errChan)
+		} else if s.httpAddress != cfg.HTTPAddress {
+			log.WithField("address", s.httpAddress).Warn("changing the http address requires a restart")
+		}
+	}
+
+	return nil
+}
+
+// Pending returns the number of loops which have not finished yet, as loops
+// only finish after collecting metrics once when running without an interval.
+func (s *supervisor) Pending() int {
+	pending := 0
+
+	for _, loop := range s.loops {
+		loop.Lock()
+		if !loop.done {
+			pending++
+		}
+		loop.Unlock()
+	}
+
+	return pending
+}
+
+// Stop stops every loop and waits for their sinks to be flushed and closed, for
+// at most the given timeout.
+func (s *supervisor) Stop(timeout time.Duration) {
+	for _, loop := range s.loops {
+		loop.Stop()
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for name, loop := range s.loops {
+		if !loop.Wait(time.Until(deadline)) {
+			log.WithField("cluster", name).Warn("timed out waiting for metrics to be flushed")
+		}
+	}
+
+	s.loops = map[string]*metricsLoop{}
+}
+
+func servePrometheus(address string, handler http.Handler, errChan chan error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+
+	log.WithField("address", address).Info("serving prometheus metrics")
+
+	errChan <- http.ListenAndServe(address, mux)
+}
+
+func serveAdmin(address string, handler http.Handler, errChan chan error) {
+	log.WithField("address", address).Info("serving /health, /ready and /status")
+
+	errChan <- http.ListenAndServe(address, handler)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisor_Apply(t *testing.T) {
+	healthy, closeHealthy := newProducer(t, resolvableNode)
+	defer closeHealthy()
+
+	cfg := config.Config{
+		Interval:               time.Minute,
+		ErrorPolicy:            config.ErrorPolicyTolerate,
+		ClientMetrics:          collector.ClientMetricsAll,
+		LatencyUnit:            collector.LatencyUnitNanoseconds,
+		DogStatsDFlushInterval: time.Second,
+		MaxBackoff:             time.Minute,
+		ReadyIntervals:         3,
+		PrometheusCollection:   "scrape",
+		Sinks:                  []string{"file:/dev/null"},
+		Clusters: []config.Cluster{
+			{Name: "foo", NSQDHTTPAddresses: []string{healthy.HTTPAddress()}},
+			{Name: "bar", NSQDHTTPAddresses: []string{healthy.HTTPAddress()}},
+		},
+	}
+
+	supervisor := newSupervisor(make(chan bool), make(chan error))
+	assert.NoError(t, supervisor.Apply(cfg))
+	assert.Len(t, supervisor.loops, 2)
+
+	foo := supervisor.loops["foo"]
+
+	// Invalid configurations are rejected without affecting running loops.
+	invalid := cfg
+	invalid.ErrorPolicy = "foo"
+	assert.Error(t, supervisor.Apply(invalid))
+
+	invalid = cfg
+	invalid.Interval = 0
+	assert.EqualError(t, supervisor.Apply(invalid), "--interval can not be removed while running")
+
+	invalid = cfg
+	invalid.Sinks = []string{"file:/nonexistent/metrics.json"}
+	assert.Error(t, supervisor.Apply(invalid))
+	assert.Len(t, supervisor.loops, 2)
+
+	cfg.Clusters = cfg.Clusters[:1]
+	assert.NoError(t, supervisor.Apply(cfg))
+	assert.Len(t, supervisor.loops, 1)
+	assert.Equal(t, foo, supervisor.loops["foo"])
+
+	// Stopping waits for the loops to close their sinks.
+	supervisor.Stop(time.Second)
+	assert.Empty(t, supervisor.loops)
+	assert.True(t, foo.Wait(time.Millisecond))
+}
+
+func TestSupervisor_Pending(t *testing.T) {
+	healthy, closeHealthy := newProducer(t, resolvableNode)
+	defer closeHealthy()
+
+	cfg := config.Config{
+		ErrorPolicy:            config.ErrorPolicyTolerate,
+		ClientMetrics:          collector.ClientMetricsAll,
+		LatencyUnit:            collector.LatencyUnitNanoseconds,
+		DogStatsDFlushInterval: time.Second,
+		MaxBackoff:             time.Minute,
+		ReadyIntervals:         3,
+		PrometheusCollection:   "scrape",
+		Sinks:                  []string{"file:/dev/null"},
+		Clusters: []config.Cluster{
+			{Name: "foo", NSQDHTTPAddresses: []string{healthy.HTTPAddress()}},
+		},
+	}
+
+	doneChan := make(chan bool)
+	supervisor := newSupervisor(doneChan, make(chan error))
+	assert.NoError(t, supervisor.Apply(cfg))
+
+	// Clusters added by a reload, which can only add an interval, are waited
+	// for as well.
+	cfg.Interval = time.Minute
+	cfg.Clusters = append(cfg.Clusters, config.Cluster{Name: "bar", NSQDHTTPAddresses: []string{healthy.HTTPAddress()}})
+	assert.NoError(t, supervisor.Apply(cfg))
+
+	assert.True(t, <-doneChan)
+	assert.Equal(t, 1, supervisor.Pending())
+
+	supervisor.Stop(time.Second)
+	assert.Zero(t, supervisor.Pending())
+}
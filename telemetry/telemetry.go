@@ -0,0 +1,244 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/ruimarinho/nsq-dogstatsd/sink"
+)
+
+// Namespace is the namespace of the metrics about nsq_to_dogstatsd itself.
+const Namespace = "nsq_to_dogstatsd"
+
+// Kinds of errors which may occur while collecting metrics from a node.
+const (
+	ErrorKindTimeout    = "timeout"
+	ErrorKindConnection = "connection"
+	ErrorKindStatus     = "status"
+	ErrorKindDecode     = "decode"
+	ErrorKindUnknown    = "unknown"
+)
+
+// Recorder records how collections went and turns them into metrics about
+// nsq_to_dogstatsd itself. It also keeps the status of every node. It is safe
+// for concurrent use.
+type Recorder struct {
+	sync.Mutex
+	metrics []collector.Metric
+	nodes   map[string]*node
+	now     func() time.Time
+}
+
+type node struct {
+	producer       producer.Producer
+	lastCollection time.Time
+	lastSuccess    time.Time
+	lastError      string
+	emitted        int
+	excluded       int
+}
+
+// NodeStatus describes the last collection of metrics from a node.
+type NodeStatus struct {
+	Address        string     `json:"address"`
+	Hostname       string     `json:"hostname"`
+	Version        string     `json:"version,omitempty"`
+	LastCollection *time.Time `json:"last_collection,omitempty"`
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Metrics        int        `json:"metrics"`
+	Excluded       int        `json:"excluded"`
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{nodes: map[string]*node{}, now: time.Now}
+}
+
+// Collection records a collection of metrics from a node, along with the
+// number of metrics emitted and excluded, and its error if it failed.
+func (r *Recorder) Collection(p producer.Producer, duration time.Duration, emitted int, excluded int, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	tags := p.GetTags()
+
+	r.metrics = append(r.metrics,
+		collector.NewMetric("collection.duration", duration.Seconds(), tags),
+		newCount("metrics.emitted", emitted, tags),
+		newCount("metrics.excluded", excluded, tags),
+	)
+
+	n := r.node(p)
+	n.lastCollection = r.now()
+	n.emitted, n.excluded = emitted, excluded
+	n.lastError = ""
+
+	if err != nil {
+		r.metrics = append(r.metrics, newCount("collection.errors", 1, append(tags, "kind:"+ErrorKind(err))))
+		n.lastError = err.Error()
+
+		return
+	}
+
+	n.lastSuccess = n.lastCollection
+}
+
+// Sent records the bytes and packets sent and dropped by the sink.
+func (r *Recorder) Sent(stats sink.Stats) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.metrics = append(r.metrics,
+		newCount("sink.bytes_sent", int(stats.BytesSent), []string{}),
+		newCount("sink.packets_sent", int(stats.PacketsSent), []string{}),
+		newCount("sink.bytes_dropped", int(stats.BytesDropped), []string{}),
+		newCount("sink.packets_dropped", int(stats.PacketsDropped), []string{}),
+	)
+}
+
+// Nodes records the nodes currently resolved. Nodes which are no longer
+// resolved are forgotten.
+func (r *Recorder) Nodes(producers []producer.Producer) {
+	r.Lock()
+	defer r.Unlock()
+
+	addresses := map[string]bool{}
+	for _, p := range producers {
+		addresses[p.HTTPAddress()] = true
+		r.node(p).producer = p
+	}
+
+	for address := range r.nodes {
+		if !addresses[address] {
+			delete(r.nodes, address)
+		}
+	}
+}
+
+// Metrics returns the metrics recorded since the last call, along with the
+// number of resolved nodes and the time of the last successful collection of
+// every node.
+func (r *Recorder) Metrics() []collector.Metric {
+	r.Lock()
+	defer r.Unlock()
+
+	metrics := append(r.metrics, collector.NewMetric("resolver.nodes", float64(len(r.nodes)), []string{}))
+	r.metrics = nil
+
+	for _, address := range r.addresses() {
+		n := r.nodes[address]
+		if !n.lastSuccess.IsZero() {
+			metrics = append(metrics, collector.NewMetric("collection.last_success", float64(n.lastSuccess.Unix()), n.producer.GetTags()))
+		}
+	}
+
+	return metrics
+}
+
+// Status returns the status of every node, ordered by address.
+func (r *Recorder) Status() []NodeStatus {
+	r.Lock()
+	defer r.Unlock()
+
+	status := []NodeStatus{}
+
+	for _, address := range r.addresses() {
+		n := r.nodes[address]
+
+		s := NodeStatus{
+			Address:   address,
+			Hostname:  n.producer.Hostname,
+			Version:   n.producer.Version,
+			LastError: n.lastError,
+			Metrics:   n.emitted,
+			Excluded:  n.excluded,
+		}
+
+		if !n.lastCollection.IsZero() {
+			lastCollection := n.lastCollection
+			s.LastCollection = &lastCollection
+		}
+
+		if !n.lastSuccess.IsZero() {
+			lastSuccess := n.lastSuccess
+			s.LastSuccess = &lastSuccess
+		}
+
+		status = append(status, s)
+	}
+
+	return status
+}
+
+// LastSuccess returns the time of the last successful collection of any node,
+// which is zero if there was none.
+func (r *Recorder) LastSuccess() time.Time {
+	r.Lock()
+	defer r.Unlock()
+
+	var last time.Time
+	for _, n := range r.nodes {
+		if n.lastSuccess.After(last) {
+			last = n.lastSuccess
+		}
+	}
+
+	return last
+}
+
+func (r *Recorder) node(p producer.Producer) *node {
+	n, ok := r.nodes[p.HTTPAddress()]
+	if !ok {
+		n = &node{producer: p}
+		r.nodes[p.HTTPAddress()] = n
+	}
+
+	return n
+}
+
+func (r *Recorder) addresses() []string {
+	addresses := make([]string, 0, len(r.nodes))
+	for address := range r.nodes {
+		addresses = append(addresses, address)
+	}
+
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// ErrorKind classifies an error returned while collecting metrics from a node.
+func ErrorKind(err error) string {
+	var statusErr fetcher.StatusError
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &statusErr):
+		return ErrorKindStatus
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorKindTimeout
+	case errors.As(err, &netErr):
+		return ErrorKindConnection
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return ErrorKindDecode
+	default:
+		return ErrorKindUnknown
+	}
+}
+
+func newCount(name string, value int, tags []string) collector.Metric {
+	metric := collector.NewMetric(name, float64(value), tags)
+	metric.Type = collector.CountType
+
+	return metric
+}
@@ -0,0 +1,88 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ruimarinho/nsq-dogstatsd/collector"
+	"github.com/ruimarinho/nsq-dogstatsd/internal/fetcher"
+	"github.com/ruimarinho/nsq-dogstatsd/producer"
+	"github.com/ruimarinho/nsq-dogstatsd/sink"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorKind(t *testing.T) {
+	var syntaxErr error = &json.SyntaxError{}
+
+	assert.Equal(t, ErrorKindStatus, ErrorKind(fetcher.StatusError{StatusCode: 500}))
+	assert.Equal(t, ErrorKindTimeout, ErrorKind(fmt.Errorf("fetching stats: %w", timeoutError{})))
+	assert.Equal(t, ErrorKindConnection, ErrorKind(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.Equal(t, ErrorKindDecode, ErrorKind(syntaxErr))
+	assert.Equal(t, ErrorKindDecode, ErrorKind(&json.UnmarshalTypeError{}))
+	assert.Equal(t, ErrorKindUnknown, ErrorKind(errors.New("foo")))
+}
+
+func TestRecorder_Sent(t *testing.T) {
+	recorder := NewRecorder()
+	recorder.Sent(sink.Stats{BytesSent: 2048, PacketsSent: 2, BytesDropped: 512, PacketsDropped: 1})
+
+	assert.Equal(t, []collector.Metric{
+		{Name: "sink.bytes_sent", Value: 2048, Type: collector.CountType, Tags: []string{}, Rate: 1},
+		{Name: "sink.packets_sent", Value: 2, Type: collector.CountType, Tags: []string{}, Rate: 1},
+		{Name: "sink.bytes_dropped", Value: 512, Type: collector.CountType, Tags: []string{}, Rate: 1},
+		{Name: "sink.packets_dropped", Value: 1, Type: collector.CountType, Tags: []string{}, Rate: 1},
+		collector.NewMetric("resolver.nodes", 0, []string{}),
+	}, recorder.Metrics())
+}
+
+func TestRecorder(t *testing.T) {
+	foo := producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 4151, Hostname: "foo", Version: "1.2.0"}
+	bar := producer.Producer{BroadcastAddress: "127.0.0.1", HTTPPort: 4152, Hostname: "bar"}
+	now := time.Unix(1500000000, 0)
+
+	recorder := NewRecorder()
+	recorder.now = func() time.Time { return now }
+
+	assert.True(t, recorder.LastSuccess().IsZero())
+
+	recorder.Nodes([]producer.Producer{foo, bar})
+	recorder.Collection(foo, 2*time.Second, 10, 5, nil)
+	recorder.Collection(bar, time.Second, 1, 0, fetcher.StatusError{StatusCode: 500})
+
+	assert.ElementsMatch(t, []collector.Metric{
+		collector.NewMetric("collection.duration", 2, []string{"node:foo"}),
+		{Name: "metrics.emitted", Value: 10, Type: collector.CountType, Tags: []string{"node:foo"}, Rate: 1},
+		{Name: "metrics.excluded", Value: 5, Type: collector.CountType, Tags: []string{"node:foo"}, Rate: 1},
+		collector.NewMetric("collection.duration", 1, []string{"node:bar"}),
+		{Name: "metrics.emitted", Value: 1, Type: collector.CountType, Tags: []string{"node:bar"}, Rate: 1},
+		{Name: "metrics.excluded", Value: 0, Type: collector.CountType, Tags: []string{"node:bar"}, Rate: 1},
+		{Name: "collection.errors", Value: 1, Type: collector.CountType, Tags: []string{"node:bar", "kind:status"}, Rate: 1},
+		collector.NewMetric("resolver.nodes", 2, []string{}),
+		collector.NewMetric("collection.last_success", 1500000000, []string{"node:foo"}),
+	}, recorder.Metrics())
+
+	assert.Equal(t, now, recorder.LastSuccess())
+	assert.Equal(t, []NodeStatus{
+		{Address: "127.0.0.1:4151", Hostname: "foo", Version: "1.2.0", LastCollection: &now, LastSuccess: &now, Metrics: 10, Excluded: 5},
+		{Address: "127.0.0.1:4152", Hostname: "bar", LastCollection: &now, LastError: "response code was 500", Metrics: 1},
+	}, recorder.Status())
+
+	// Recorded metrics are only returned once, except for the number of nodes
+	// and the time of the last successful collection of nodes still resolved.
+	assert.Len(t, recorder.Metrics(), 2)
+
+	recorder.Nodes([]producer.Producer{bar})
+	assert.Equal(t, []collector.Metric{collector.NewMetric("resolver.nodes", 1, []string{})}, recorder.Metrics())
+	assert.True(t, recorder.LastSuccess().IsZero())
+	assert.Len(t, recorder.Status(), 1)
+}